@@ -1,10 +1,48 @@
 package driver
 
 import (
+	"bytes"
+	"log"
+	"strconv"
+
 	"github.com/stardustapp/dustgo/lib/base"
 	"github.com/stardustapp/dustgo/lib/inmem"
 )
 
-func (p *Process) AssembleStdoutImpl() base.File {
-	return inmem.NewFile("stdout", []byte{})
+// Concatenates every retained byte packet into one File
+// Accepts "stdout" (the default), "stderr", or "both" to select streams.
+// Packets are sequenced as they arrive, so "both" comes out interleaved
+// in timestamp order.
+// Only the latest maxStdoutPackets are kept, so long output loses its
+// start; the process's stdout-horizon shows where what's left begins.
+func (p *Process) AssembleStdoutImpl(stream string) base.File {
+	if stream == "" {
+		stream = "stdout"
+	}
+	if stream != "stdout" && stream != "stderr" && stream != "both" {
+		log.Println("WARN: AssembleStdout(): unknown stream", stream)
+		return nil
+	}
+
+	// hold off new packets and trimming while we read
+	p.outMutex.Lock()
+	defer p.outMutex.Unlock()
+
+	horizon, _ := strconv.Atoi(p.StdoutHorizon)
+	latest, _ := strconv.Atoi(p.StdoutLatest.Get())
+
+	var buf bytes.Buffer
+	for seq := horizon; seq <= latest; seq++ {
+		ent, ok := p.Stdout.Fetch(strconv.Itoa(seq))
+		if !ok {
+			continue
+		}
+		packet := ent.(*BytePacket)
+		if stream != "both" && packet.Stream != stream {
+			continue
+		}
+		buf.Write(packet.Data.Read(0, int(packet.Data.GetSize())))
+	}
+
+	return inmem.NewFile(stream, buf.Bytes())
 }
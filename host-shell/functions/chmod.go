@@ -0,0 +1,50 @@
+package driver
+
+import (
+	"log"
+	"os"
+	"strconv"
+)
+
+// Changes the permission bits of an existing path, given in octal
+func (s *Session) ChmodImpl(input *ChmodInput) string {
+	mode, err := strconv.ParseUint(input.Mode, 8, 32)
+	if err != nil || mode > 07777 {
+		return "Failed: mode must be octal, like 0644"
+	}
+
+	subPath, ok := s.resolvePath("Chmod():", input.Path)
+	if !ok {
+		return "Failed: invalid path"
+	}
+	if !s.allowsWrite("Chmod()", subPath, true) {
+		return "Failed: mount doesn't allow changes"
+	}
+
+	// chmod always follows links, so refuse them
+	fileInfo, err := os.Lstat(subPath)
+	if err != nil {
+		return "Failed: " + err.Error()
+	}
+	if fileInfo.Mode()&os.ModeSymlink != 0 {
+		return "Failed: links don't have their own mode"
+	}
+
+	// carry the special bits over to Go's representation
+	fileMode := os.FileMode(mode & 0777)
+	if mode&04000 != 0 {
+		fileMode |= os.ModeSetuid
+	}
+	if mode&02000 != 0 {
+		fileMode |= os.ModeSetgid
+	}
+	if mode&01000 != 0 {
+		fileMode |= os.ModeSticky
+	}
+
+	if err := os.Chmod(subPath, fileMode); err != nil {
+		log.Println("WARN Chmod():", subPath, "failed:", err)
+		return "Failed: " + err.Error()
+	}
+	return "Ok"
+}
@@ -0,0 +1,48 @@
+package driver
+
+import (
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/stardustapp/dustgo/lib/base"
+)
+
+// Recursively copies a tree to a new path within the fs-root
+// The source is either a path on this mount, or any other Folder
+func (s *Session) CopyImpl(input *CopyInput) *OperationReport {
+	var source base.Entry = input.Source
+	if input.SourcePath != "" {
+		var ok bool
+		if source, ok = s.fetchPath("Copy():", input.SourcePath); !ok {
+			return &OperationReport{Status: "Failed: source not found"}
+		}
+	}
+	if source == nil {
+		return &OperationReport{Status: "Failed: no source given"}
+	}
+
+	destPath, ok := s.resolvePath("Copy():", input.Destination)
+	if !ok || destPath == s.fsPrefix {
+		return &OperationReport{Status: "Failed: invalid destination path"}
+	}
+	if folder, ok := source.(*hostFolder); ok && pathWithin(folder.prefix, destPath) {
+		return &OperationReport{Status: "Failed: can't copy a folder into itself"}
+	}
+
+	var copied []string
+	relPath := strings.TrimPrefix(filepath.Clean("/"+input.Destination), "/")
+	ok = s.parentOf(destPath).putRecorded(filepath.Base(destPath), source, relPath, &copied)
+
+	status := "Ok"
+	if !ok {
+		status = "Failed: some entries weren't copied"
+	}
+	log.Println("Copy(): copied", len(copied), "entries to", destPath)
+	return &OperationReport{
+		Status: status,
+		Count:  strconv.Itoa(len(copied)),
+		Paths:  buildArrayFolder(copied...),
+	}
+}
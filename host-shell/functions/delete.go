@@ -0,0 +1,54 @@
+package driver
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Recursively removes a path within the fs-root
+// A dry run only reports what would have been removed
+func (s *Session) DeleteImpl(input *DeleteInput) *OperationReport {
+	subPath, ok := s.resolvePath("Delete():", input.Path)
+	if !ok || subPath == s.fsPrefix {
+		return &OperationReport{Status: "Failed: invalid path"}
+	}
+	dryRun := input.DryRun == "yes"
+	if !dryRun && !s.allowsWrite("Delete()", subPath, true) {
+		return &OperationReport{Status: "Failed: mount doesn't allow changes"}
+	}
+
+	// walking never follows links, so nothing outside gets listed
+	var removed []string
+	relRoot := strings.TrimPrefix(filepath.Clean("/"+input.Path), "/")
+	err := filepath.Walk(subPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(subPath, path)
+		removed = append(removed, filepath.ToSlash(filepath.Join(relRoot, rel)))
+		return nil
+	})
+	if err != nil {
+		log.Println("WARN Delete(): walking", subPath, "failed:", err)
+		return &OperationReport{Status: "Failed: " + err.Error()}
+	}
+
+	status := "Ok: dry run"
+	if !dryRun {
+		log.Println("WARN Delete(): DELETING", len(removed), "entries at", subPath)
+		status = "Ok"
+		if err := os.RemoveAll(subPath); err != nil {
+			log.Println("WARN Delete(): FAILED to delete", subPath, err)
+			status = "Failed: " + err.Error()
+		}
+	}
+
+	return &OperationReport{
+		Status: status,
+		Count:  strconv.Itoa(len(removed)),
+		Paths:  buildArrayFolder(removed...),
+	}
+}
@@ -0,0 +1,172 @@
+package driver
+
+import (
+	"log"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/stardustapp/dustgo/lib/toolbox"
+)
+
+// Refreshing any faster would just be wasted syscalls and walks
+const minUsageRefresh = 10 * time.Second
+
+// Refreshing entries nobody has asked for in this long are stopped,
+// or in three of their intervals, whichever is longer
+const usageIdleTimeout = 10 * time.Minute
+
+// How many refreshing entries each session can have at once
+const maxUsageRefreshers = 16
+
+// Reports capacity of the filesystem backing the fs-root, like `df`
+// Given a refresh interval, the values keep updating in place,
+// and asking again with the same interval returns the same entry.
+// Asking again is also what keeps it refreshing, see usageIdleTimeout.
+// Once it stops, stopped-at is set and a fresh entry has to be asked for.
+func (s *Session) DfImpl(interval string) *FilesystemUsage {
+	refresh, ok := parseRefreshInterval("Df():", interval)
+	if !ok {
+		return nil
+	}
+
+	usage := &FilesystemUsage{StoppedAt: toolbox.NewReactiveString("stopped-at", "")}
+	if refresh == 0 {
+		if !s.statFilesystem(usage) {
+			return nil
+		}
+		usage.StoppedAt.Set(usage.CheckedAt.Get())
+		return usage
+	}
+
+	entry := s.refreshUsage("Df():", "df@"+refresh.String(), refresh, usage, usage.StoppedAt, func() bool {
+		return s.statFilesystem(usage)
+	})
+	if entry == nil {
+		return nil
+	}
+	return entry.(*FilesystemUsage)
+}
+
+// An entry which keeps updating in the background
+type usageRefresher struct {
+	entry     interface{}
+	stoppedAt *toolbox.ReactiveString
+	update    func() bool
+	lastAsked time.Time
+}
+
+// Returns the entry already refreshing under key, if any,
+// otherwise fills in the given one and keeps it refreshing
+func (s *Session) refreshUsage(op, key string, interval time.Duration, entry interface{}, stoppedAt *toolbox.ReactiveString, update func() bool) interface{} {
+	s.usageMutex.Lock()
+	if r, ok := s.usageRefreshers[key]; ok {
+		r.lastAsked = time.Now()
+		s.usageMutex.Unlock()
+		return r.entry
+	}
+	s.usageMutex.Unlock()
+
+	// the first update can be a long walk, so it's done unlocked
+	if !update() {
+		return nil
+	}
+
+	s.usageMutex.Lock()
+	defer s.usageMutex.Unlock()
+	if r, ok := s.usageRefreshers[key]; ok {
+		// someone else got here first
+		r.lastAsked = time.Now()
+		return r.entry
+	}
+	if len(s.usageRefreshers) >= maxUsageRefreshers {
+		log.Println("WARN", op, "already refreshing", len(s.usageRefreshers), "entries, refusing", key)
+		return nil
+	}
+	if s.usageRefreshers == nil {
+		s.usageRefreshers = make(map[string]*usageRefresher)
+	}
+
+	r := &usageRefresher{entry: entry, stoppedAt: stoppedAt, update: update, lastAsked: time.Now()}
+	s.usageRefreshers[key] = r
+	go s.runUsageRefresher(key, r, interval)
+	return entry
+}
+
+// Updates the entry every interval until nobody's asked for it in a while,
+// then marks it stopped so anyone still watching can tell
+func (s *Session) runUsageRefresher(key string, r *usageRefresher, interval time.Duration) {
+	idleTimeout := usageIdleTimeout
+	if idleTimeout < 3*interval {
+		idleTimeout = 3 * interval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.usageMutex.Lock()
+		idle := time.Since(r.lastAsked) > idleTimeout
+		if idle {
+			delete(s.usageRefreshers, key)
+		}
+		s.usageMutex.Unlock()
+
+		if idle {
+			log.Println("Stopped refreshing idle usage entry", key)
+			r.stoppedAt.Set(time.Now().UTC().Format(time.RFC3339Nano))
+			return
+		}
+		r.update()
+	}
+}
+
+// Fills in the usage entry, creating its strings the first time
+func (s *Session) statFilesystem(usage *FilesystemUsage) bool {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(s.fsPrefix, &stat); err != nil {
+		log.Println("WARN Df(): statfs", s.fsPrefix, "failed:", err)
+		return false
+	}
+
+	blockSize := uint64(stat.Bsize)
+	setUsageString(&usage.TotalBytes, "total-bytes", stat.Blocks*blockSize)
+	setUsageString(&usage.FreeBytes, "free-bytes", stat.Bfree*blockSize)
+	setUsageString(&usage.AvailableBytes, "available-bytes", stat.Bavail*blockSize)
+	setUsageString(&usage.UsedBytes, "used-bytes", (stat.Blocks-stat.Bfree)*blockSize)
+	setUsageString(&usage.TotalInodes, "total-inodes", stat.Files)
+	setUsageString(&usage.FreeInodes, "free-inodes", stat.Ffree)
+	setUsageString(&usage.UsedInodes, "used-inodes", stat.Files-stat.Ffree)
+	setCheckedAt(&usage.CheckedAt)
+	return true
+}
+
+func setUsageString(str **toolbox.ReactiveString, name string, value uint64) {
+	if *str == nil {
+		*str = toolbox.NewReactiveString(name, "")
+	}
+	(*str).Set(strconv.FormatUint(value, 10))
+}
+
+func setCheckedAt(str **toolbox.ReactiveString) {
+	if *str == nil {
+		*str = toolbox.NewReactiveString("checked-at", "")
+	}
+	(*str).Set(time.Now().UTC().Format(time.RFC3339Nano))
+}
+
+// Reads an optional refresh interval, where empty means just once
+func parseRefreshInterval(op, interval string) (time.Duration, bool) {
+	if interval == "" {
+		return 0, true
+	}
+	refresh, err := time.ParseDuration(interval)
+	if err != nil {
+		log.Println("WARN", op, "invalid refresh interval", interval, err)
+		return 0, false
+	}
+	if refresh < minUsageRefresh {
+		log.Println("WARN", op, "raising refresh interval", refresh, "to", minUsageRefresh)
+		refresh = minUsageRefresh
+	}
+	return refresh, true
+}
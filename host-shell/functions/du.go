@@ -0,0 +1,83 @@
+package driver
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/stardustapp/dustgo/lib/toolbox"
+)
+
+// Totals up the size of a subtree within the fs-root, like `du`
+// Hardlinked files only count once. Links are never followed.
+// Given a refresh interval, the walk repeats and the values update in place,
+// for as long as the same path and interval keep being asked for.
+// Once it stops, stopped-at is set and a fresh entry has to be asked for.
+func (s *Session) DuImpl(input *DuInput) *TreeUsage {
+	subPath, ok := s.resolvePath("Du():", input.Path)
+	if !ok {
+		return nil
+	}
+	refresh, ok := parseRefreshInterval("Du():", input.RefreshInterval)
+	if !ok {
+		return nil
+	}
+
+	relPath := strings.TrimPrefix(filepath.Clean("/"+input.Path), "/")
+	usage := &TreeUsage{Path: relPath, StoppedAt: toolbox.NewReactiveString("stopped-at", "")}
+	if refresh == 0 {
+		if !s.walkUsage(subPath, usage) {
+			return nil
+		}
+		usage.StoppedAt.Set(usage.CheckedAt.Get())
+		return usage
+	}
+
+	key := "du:" + relPath + "@" + refresh.String()
+	entry := s.refreshUsage("Du():", key, refresh, usage, usage.StoppedAt, func() bool {
+		return s.walkUsage(subPath, usage)
+	})
+	if entry == nil {
+		return nil
+	}
+	return entry.(*TreeUsage)
+}
+
+// Walks the whole subtree, then updates the entry all at once
+func (s *Session) walkUsage(subPath string, usage *TreeUsage) bool {
+	var size, allocated, count uint64
+	seen := make(map[[2]uint64]bool)
+	err := filepath.Walk(subPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// unreadable folders are left out, not fatal
+			log.Println("WARN Du(): skipping", path, "-", err)
+			return nil
+		}
+
+		if sys, ok := info.Sys().(*syscall.Stat_t); ok {
+			inode := [2]uint64{uint64(sys.Dev), uint64(sys.Ino)}
+			if seen[inode] {
+				return nil
+			}
+			if sys.Nlink > 1 {
+				seen[inode] = true
+			}
+			allocated += uint64(sys.Blocks) * 512
+		}
+		size += uint64(info.Size())
+		count += 1
+		return nil
+	})
+	if err != nil {
+		log.Println("WARN Du(): walking", subPath, "failed:", err)
+		return false
+	}
+
+	setUsageString(&usage.SizeBytes, "size-bytes", size)
+	setUsageString(&usage.AllocatedBytes, "allocated-bytes", allocated)
+	setUsageString(&usage.EntryCount, "entry-count", count)
+	setCheckedAt(&usage.CheckedAt)
+	return true
+}
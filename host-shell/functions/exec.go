@@ -1,14 +1,744 @@
 package driver
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/stardustapp/dustgo/lib/base"
+	"github.com/stardustapp/dustgo/lib/inmem"
 	"github.com/stardustapp/dustgo/lib/toolbox"
+
+	"github.com/creack/pty"
 )
 
+// How many byte packets to retain before trimming old output
+// Line buffering makes a packet per line, so this is sized for long builds.
+const maxStdoutPackets = 10000
+
+// How long a timed-out process has to exit after TERM, before KILL
+const killGracePeriod = 10 * time.Second
+
+// How long output can stay open after the process exits, such as when
+// it leaves children running in the background, before it's cut off
+const outputGracePeriod = 5 * time.Second
+
+// Size of new pseudo-terminals, until they're resized
+const defaultTtyRows, defaultTtyCols = 24, 80
+
+// How often finished processes and stray zombies are cleaned up
+const reapInterval = 30 * time.Second
+
+// From linux/prctl.h, which the syscall package doesn't carry
+const prSetChildSubreaper = 36
+
+// Sessions whose finished processes get pruned, all by one reaper
+var reapedSessions []*Session
+var reaperMutex sync.Mutex
+var reaperOnce sync.Once
+
+// Children that are being waited on by their own Process
+// Anything else that turns into a zombie gets reaped in the background
+var trackedPids = make(map[int]bool)
+var trackedMutex sync.Mutex
+
+// Spawns a real POSIX process, rooted in the session's filesystem prefix
+// The process is tracked reactively until it exits
 func (s *Session) ExecImpl(opts *ExecOpts) *Process {
-	return &Process{
-		Pid:          "-1",
-		Status:       toolbox.NewReactiveString("status", "Pending"),
-		ExitCode:     toolbox.NewReactiveString("exit-code", ""),
-		StdoutLatest: toolbox.NewReactiveString("stdout-latest", "-1"),
+	if !s.shellEnabled {
+		log.Println("WARN: Exec of", opts.Executable, "rejected - mount doesn't enable-shell")
+		return nil
+	}
+
+	executable, err := s.resolveExec(opts.Executable)
+	if err != nil {
+		log.Println("WARN: Exec of", opts.Executable, "rejected -", err)
+		return nil
+	}
+
+	args := readArrayFolder(opts.Arguments)
+	cmd := exec.Command(executable, args...)
+	cmd.Args[0] = opts.Executable
+	cmd.Dir = s.fsPrefix
+	cmd.SysProcAttr = &syscall.SysProcAttr{}
+	if cmd.Env, err = s.buildEnvironment(opts); err != nil {
+		log.Println("WARN: Exec of", opts.Executable, "rejected -", err)
+		return nil
+	}
+
+	// Processes can start deeper within the fs-root
+	if opts.WorkingDirectory != "" {
+		workDir, ok := s.resolvePath("Exec():", opts.WorkingDirectory)
+		if ok {
+			workDir, err = filepath.EvalSymlinks(workDir)
+		}
+		if !ok || err != nil || !pathWithin(s.fsPrefix, workDir) {
+			log.Println("WARN: Exec of", opts.Executable, "rejected - bad working-directory", opts.WorkingDirectory)
+			return nil
+		}
+		cmd.Dir = workDir
+	}
+
+	switch opts.OutputBuffering {
+	case "", "line", "raw", "full":
+	default:
+		log.Println("WARN: Unknown output-buffering", opts.OutputBuffering, "- using line")
+	}
+
+	p := &Process{
+		Opts:          opts,
+		Pid:           "-1",
+		Status:        toolbox.NewReactiveString("status", "Pending"),
+		ExitCode:      toolbox.NewReactiveString("exit-code", ""),
+		EndTime:       toolbox.NewReactiveString("end-time", ""),
+		Stdout:        inmem.NewFolder("stdout"),
+		StdoutHorizon: "0",
+		StdoutLatest:  toolbox.NewReactiveString("stdout-latest", "-1"),
+
+		cmd:    cmd,
+		exited: make(chan struct{}),
+	}
+
+	// Accept timeouts as durations or as plain seconds
+	var timeout time.Duration
+	if opts.Timeout != "" {
+		if timeout, err = time.ParseDuration(opts.Timeout); err != nil {
+			seconds, err := strconv.Atoi(opts.Timeout)
+			if err != nil {
+				p.Status.Set("Failed: invalid timeout " + opts.Timeout)
+				return p
+			}
+			timeout = time.Duration(seconds) * time.Second
+		}
+	}
+
+	// Check the rest of the options before anything is started
+	limits, err := parseLimits(opts)
+	if err != nil {
+		p.Status.Set("Failed: " + err.Error())
+		return p
+	}
+	if cmd.SysProcAttr.Credential, err = parseCredential(opts); err != nil {
+		p.Status.Set("Failed: " + err.Error())
+		return p
+	}
+	if len(limits) > 0 {
+		// the helper sets the limits, then becomes the real executable
+		cmd.Args = append([]string{limitHelperName, encodeLimits(limits), cmd.Path}, cmd.Args...)
+		cmd.Path = "/proc/self/exe"
+	}
+
+	// Output is drained separately from waiting on the process
+	var pumps sync.WaitGroup
+
+	// The zombie reaper mustn't see our child before it's tracked
+	trackedMutex.Lock()
+	if opts.EnableTty == "yes" {
+		if err = p.startTty(&pumps); err != nil {
+			log.Println("WARN: Failed to start", opts.Executable, "on a pty:", err)
+		}
+	} else {
+		if err = p.startPiped(&pumps); err != nil {
+			log.Println("WARN: Failed to start", opts.Executable, err)
+		}
+	}
+	if err == nil {
+		trackedPids[cmd.Process.Pid] = true
+	}
+	trackedMutex.Unlock()
+
+	if err != nil {
+		p.Status.Set("Failed: " + err.Error())
+		return p
+	}
+
+	p.Pid = strconv.Itoa(cmd.Process.Pid)
+	p.StartTime = time.Now().UTC().Format(time.RFC3339Nano)
+	p.Status.Set("Running")
+
+	s.procMutex.Lock()
+	s.retirePid(p.Pid)
+	if ok := s.Processes.Put(p.Pid, p); !ok {
+		log.Println("WARN: Process store rejected pid", p.Pid)
+	}
+	s.procMutex.Unlock()
+
+	go p.wait(&pumps)
+	if timeout > 0 {
+		go p.enforceTimeout(timeout)
+	}
+	return p
+}
+
+// Starts the process with plain pipes, capturing both output streams
+func (p *Process) startPiped(pumps *sync.WaitGroup) error {
+	// Get a process group, so signals reach any children too
+	p.cmd.SysProcAttr.Setpgid = true
+
+	stdout, err := p.cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := p.cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+
+	// Only keep stdin open when asked to
+	if p.Opts.EnableStdin == "yes" {
+		if p.stdin, err = p.cmd.StdinPipe(); err != nil {
+			return err
+		}
+	}
+
+	log.Println("Starting process", p.cmd.Args, "in", p.cmd.Dir)
+	if err := p.cmd.Start(); err != nil {
+		return err
+	}
+
+	p.outputs = []io.Closer{stdout, stderr}
+	pumps.Add(2)
+	go p.pumpOutput("stdout", stdout, pumps)
+	go p.pumpOutput("stderr", stderr, pumps)
+	return nil
+}
+
+// Starts the process attached to a new pseudo-terminal
+// The terminal merges all output, and stdin goes to the pty master.
+// The pty's new session also makes the process a group leader.
+func (p *Process) startTty(pumps *sync.WaitGroup) error {
+	log.Println("Starting process", p.cmd.Args, "in", p.cmd.Dir, "with a pty")
+	tty, err := pty.StartWithSize(p.cmd, &pty.Winsize{
+		Rows: defaultTtyRows,
+		Cols: defaultTtyCols,
+	})
+	if err != nil {
+		return err
+	}
+	p.tty = tty
+	p.stdin = tty
+
+	pumps.Add(1)
+	go p.pumpOutput("stdout", tty, pumps)
+	return nil
+}
+
+// Finds the host path that an exec would run, and checks it's allowed
+// Absolute paths are used as-is, while relative paths are within the
+// fs-root. Bare names can also fall back to searching the $PATH.
+func (s *Session) resolveExec(name string) (string, error) {
+	if name == "" {
+		return "", errors.New("no executable given")
+	}
+
+	path := name
+	if !filepath.IsAbs(name) {
+		path = filepath.Join(s.fsPrefix, name)
+		if !pathWithin(s.fsPrefix, path) {
+			return "", errors.New(name + " is outside of the fs-root")
+		}
+		if _, err := os.Stat(path); err != nil && !strings.Contains(name, "/") {
+			if path, err = exec.LookPath(name); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	// compare with the allow-list using real paths, and then run that
+	// real path, so a link can't be swapped out after it's checked
+	if s.allowedExecs != nil {
+		realPath, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			return "", err
+		}
+		for _, allowed := range s.allowedExecs {
+			if allowed == realPath {
+				return realPath, nil
+			}
+		}
+		return "", errors.New(realPath + " isn't an allowed executable")
+	}
+	return path, nil
+}
+
+// Resolves an allow-list entry the same way as resolveExec,
+// except that relative names are never searched for in $PATH
+func (s *Session) resolveAllowedExec(name string) string {
+	path := name
+	if !filepath.IsAbs(name) {
+		path = filepath.Join(s.fsPrefix, name)
+	}
+	if realPath, err := filepath.EvalSymlinks(path); err == nil {
+		return realPath
+	}
+	log.Println("WARN: Allowed executable", path, "couldn't be resolved")
+	return filepath.Clean(path)
+}
+
+// Builds the child's environment from the exec options
+// Variables extend the anchor's own environment, unless the mode is "replace".
+// With an allow-list, variables that would make the allowed executables
+// load or run other code are refused.
+func (s *Session) buildEnvironment(opts *ExecOpts) ([]string, error) {
+	replace := opts.EnvironmentMode == "replace"
+	if opts.Environment == nil && !replace {
+		return nil, nil // inherit as-is
+	}
+
+	env := make([]string, 0)
+	if !replace {
+		env = append(env, os.Environ()...)
+	}
+	if opts.Environment != nil {
+		for _, name := range opts.Environment.Children() {
+			if name == "" || strings.ContainsAny(name, "=\x00") {
+				log.Printf("WARN: Skipping invalid environment variable %q", name)
+				continue
+			}
+			if s.allowedExecs != nil && isLoaderVariable(name) {
+				return nil, errors.New("environment variable " + name + " isn't allowed with allowed-executables")
+			}
+			if ent, ok := opts.Environment.Fetch(name); ok {
+				if str, ok := ent.(base.String); ok {
+					// later duplicates win, so these override the anchor's
+					env = append(env, name+"="+str.Get())
+				}
+			}
+		}
+	}
+	return env, nil
+}
+
+// Whether a variable changes which code the dynamic loader or PATH lookups pick up
+func isLoaderVariable(name string) bool {
+	return strings.HasPrefix(name, "LD_") || name == "GCONV_PATH" || name == "PATH"
+}
+
+// Reads the resource limits requested in the exec options
+func parseLimits(opts *ExecOpts) (map[int]uint64, error) {
+	limits := make(map[int]uint64)
+	for resource, value := range map[int]string{
+		syscall.RLIMIT_CPU:    opts.LimitCpuSeconds,
+		syscall.RLIMIT_AS:     opts.LimitMemoryBytes,
+		syscall.RLIMIT_NOFILE: opts.LimitOpenFiles,
+	} {
+		if value == "" {
+			continue
+		}
+		limit, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return nil, errors.New("invalid limit " + value)
+		}
+		limits[resource] = limit
+	}
+	return limits, nil
+}
+
+// Packs limits into the helper's first argument, as resource=limit pairs
+func encodeLimits(limits map[int]uint64) string {
+	var pairs []string
+	for resource, limit := range limits {
+		pairs = append(pairs, strconv.Itoa(resource)+"="+strconv.FormatUint(limit, 10))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// Processes with resource limits start out as a copy of the anchor,
+// run as this helper. It sets the limits on itself before exec'ing
+// the real executable in place, so nothing ever runs unlimited.
+// Arguments are the encoded limits, the executable, then its argv.
+const limitHelperName = "host-shell-limits"
+
+func init() {
+	if len(os.Args) < 4 || os.Args[0] != limitHelperName {
+		return
+	}
+
+	for _, pair := range strings.Split(os.Args[1], ",") {
+		parts := strings.SplitN(pair+"=", "=", 3)
+		resource, err := strconv.Atoi(parts[0])
+		var limit uint64
+		if err == nil {
+			limit, err = strconv.ParseUint(parts[1], 10, 64)
+		}
+		if err == nil {
+			err = syscall.Setrlimit(resource, &syscall.Rlimit{Cur: limit, Max: limit})
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "host-shell: couldn't set limit", pair, "-", err)
+			os.Exit(126)
+		}
+	}
+
+	err := syscall.Exec(os.Args[2], os.Args[3:], os.Environ())
+	fmt.Fprintln(os.Stderr, "host-shell: couldn't exec", os.Args[2], "-", err)
+	os.Exit(127)
+}
+
+// Reads which user and group to run as, if not the anchor's own
+// A lone uid also takes on that user's primary group
+func parseCredential(opts *ExecOpts) (*syscall.Credential, error) {
+	if opts.RunAsUid == "" && opts.RunAsGid == "" {
+		return nil, nil
+	}
+
+	uid := uint64(os.Getuid())
+	gidStr := opts.RunAsGid
+	var err error
+	if opts.RunAsUid != "" {
+		if uid, err = strconv.ParseUint(opts.RunAsUid, 10, 32); err != nil {
+			return nil, errors.New("invalid run-as-uid " + opts.RunAsUid)
+		}
+		if gidStr == "" {
+			account, err := user.LookupId(opts.RunAsUid)
+			if err != nil {
+				return nil, errors.New("run-as-uid has no primary group: " + err.Error())
+			}
+			gidStr = account.Gid
+		}
+	}
+	gid, err := strconv.ParseUint(gidStr, 10, 32)
+	if err != nil {
+		return nil, errors.New("invalid run-as-gid " + gidStr)
+	}
+
+	return &syscall.Credential{
+		Uid: uint32(uid),
+		Gid: uint32(gid),
+	}, nil
+}
+
+// Blocks until the process is gone, then records how it ended
+// Output is drained afterwards, since children that outlive the process
+// can hold the streams open long after it exited.
+func (p *Process) wait(pumps *sync.WaitGroup) {
+	state, err := p.cmd.Process.Wait()
+
+	trackedMutex.Lock()
+	delete(trackedPids, p.cmd.Process.Pid)
+	trackedMutex.Unlock()
+
+	p.EndTime.Set(time.Now().UTC().Format(time.RFC3339Nano))
+	if err != nil {
+		log.Println("WARN: Couldn't wait on pid", p.Pid, err)
+		p.Status.Set("Failed: " + err.Error())
+	} else {
+		p.ExitCode.Set(strconv.Itoa(state.ExitCode()))
+		if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			log.Println("Process", p.Pid, "was killed by", status.Signal())
+			p.Status.Set("Killed: " + signalName(status.Signal()))
+		} else {
+			log.Println("Process", p.Pid, "exited with", state.ExitCode())
+			p.Status.Set("Exited")
+		}
+	}
+	close(p.exited)
+
+	drained := make(chan struct{})
+	go func() {
+		pumps.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(outputGracePeriod):
+		log.Println("Output of pid", p.Pid, "is still open - closing it")
+	}
+	p.closeStreams()
+	<-drained
+}
+
+// Closes our ends of the process's streams, which exec.Cmd.Wait
+// would otherwise do. Pending output reads return right away.
+func (p *Process) closeStreams() {
+	for _, output := range p.outputs {
+		output.Close()
+	}
+
+	// a pty master is stdin as well
+	p.stdinMutex.Lock()
+	defer p.stdinMutex.Unlock()
+	if p.tty != nil {
+		p.tty.Close()
+	} else if p.stdin != nil {
+		p.stdin.Close()
+	}
+	p.stdin = nil
+}
+
+// Asks the process to stop once its time is up, then insists
+func (p *Process) enforceTimeout(timeout time.Duration) {
+	select {
+	case <-p.exited:
+		return
+	case <-time.After(timeout):
+	}
+
+	log.Println("Process", p.Pid, "timed out after", timeout, "- sending TERM")
+	p.sendSignal(syscall.SIGTERM)
+
+	select {
+	case <-p.exited:
+	case <-time.After(killGracePeriod):
+		log.Println("Process", p.Pid, "ignored TERM - sending KILL")
+		p.sendSignal(syscall.SIGKILL)
+	}
+}
+
+// Reads one output stream into sequenced byte packets,
+// chunked according to the process's output-buffering option
+func (p *Process) pumpOutput(stream string, pipe io.Reader, pumps *sync.WaitGroup) {
+	defer pumps.Done()
+
+	switch p.Opts.OutputBuffering {
+
+	case "full":
+		// hold everything until the stream closes
+		data, err := ioutil.ReadAll(pipe)
+		if err != nil && !isEndOfOutput(err) {
+			log.Println("WARN: Failed reading", stream, "of pid", p.Pid, err)
+		}
+		if len(data) > 0 {
+			p.addPacket(stream, data)
+		}
+
+	case "raw":
+		// pass along whatever each read returns
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := pipe.Read(buf)
+			if n > 0 {
+				p.addPacket(stream, append([]byte(nil), buf[:n]...))
+			}
+			if err != nil {
+				if !isEndOfOutput(err) {
+					log.Println("WARN: Failed reading", stream, "of pid", p.Pid, err)
+				}
+				return
+			}
+		}
+
+	default:
+		// one packet per line, splitting extremely long lines
+		reader := bufio.NewReaderSize(pipe, 64*1024)
+		for {
+			line, err := reader.ReadSlice('\n')
+			if len(line) > 0 {
+				p.addPacket(stream, append([]byte(nil), line...))
+			}
+			if err == bufio.ErrBufferFull {
+				continue
+			}
+			if err != nil {
+				if !isEndOfOutput(err) {
+					log.Println("WARN: Failed reading", stream, "of pid", p.Pid, err)
+				}
+				return
+			}
+		}
+	}
+}
+
+// Output streams end with EOF, except pty masters which return EIO
+// once the terminal's last process has exited. Streams that outlived
+// the process end by being closed.
+func isEndOfOutput(err error) bool {
+	if err == io.EOF {
+		return true
+	}
+	if pathErr, ok := err.(*os.PathError); ok {
+		return pathErr.Err == syscall.EIO || pathErr.Err == os.ErrClosed
+	}
+	return false
+}
+
+// Stores a chunk of output as the next byte packet
+func (p *Process) addPacket(stream string, data []byte) {
+	p.outMutex.Lock()
+	defer p.outMutex.Unlock()
+
+	i, _ := strconv.Atoi(p.StdoutLatest.Get())
+	nextSeq := strconv.Itoa(i + 1)
+	p.Stdout.Put(nextSeq, &BytePacket{
+		Data:      inmem.NewFile("data", data),
+		Stream:    stream,
+		Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
+	})
+	p.StdoutLatest.Set(nextSeq)
+
+	// Trim old packets
+	horizon, _ := strconv.Atoi(p.StdoutHorizon)
+	maxOld := i - maxStdoutPackets
+	for horizon < maxOld {
+		p.Stdout.Put(strconv.Itoa(horizon), nil)
+		horizon++
+		p.StdoutHorizon = strconv.Itoa(horizon)
+	}
+}
+
+// Moves a finished process aside when the kernel hands its pid out again,
+// keying it by pid and start time so its history is kept
+// Callers hold procMutex.
+func (s *Session) retirePid(pid string) {
+	ent, ok := s.Processes.Fetch(pid)
+	if !ok {
+		return
+	}
+	old, ok := ent.(*Process)
+	if !ok {
+		return
+	}
+
+	startTime, _ := time.Parse(time.RFC3339Nano, old.StartTime)
+	key := pid + "-" + strconv.FormatInt(startTime.UnixNano(), 10)
+	log.Println("Pid", pid, "was reused, moving its old process to", key)
+	s.Processes.Put(key, old)
+}
+
+// Hands the session's processes to the reaper, starting it if needed
+// The anchor becomes a subreaper, so orphaned grandchildren come back
+// to it instead of init, and the reaper can collect them.
+func (s *Session) startReaping() {
+	reaperMutex.Lock()
+	reapedSessions = append(reapedSessions, s)
+	reaperMutex.Unlock()
+	reaperOnce.Do(func() {
+		if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetChildSubreaper, 1, 0); errno != 0 {
+			log.Println("WARN: Couldn't become a subreaper, orphans will go to init:", errno)
+		}
+		go reapProcesses()
+	})
+}
+
+// Periodically forgets finished processes past each session's retention,
+// and collects any zombies that nobody else is waiting on
+func reapProcesses() {
+	for range time.Tick(reapInterval) {
+		reaperMutex.Lock()
+		sessions := append([]*Session(nil), reapedSessions...)
+		reaperMutex.Unlock()
+
+		for _, s := range sessions {
+			s.pruneProcesses()
+		}
+		collectZombies()
+	}
+}
+
+// Drops finished processes that are too old, or too many
+func (s *Session) pruneProcesses() {
+	s.procMutex.Lock()
+	defer s.procMutex.Unlock()
+
+	type finished struct {
+		pid     string
+		endTime time.Time
+	}
+	var done []finished
+	for _, pid := range s.Processes.Children() {
+		if ent, ok := s.Processes.Fetch(pid); ok {
+			if p, ok := ent.(*Process); ok && p.EndTime.Get() != "" {
+				endTime, _ := time.Parse(time.RFC3339Nano, p.EndTime.Get())
+				done = append(done, finished{pid, endTime})
+			}
+		}
+	}
+
+	// newest first, so the count keeps the most recent
+	sort.Slice(done, func(i, j int) bool {
+		return done[i].endTime.After(done[j].endTime)
+	})
+	for idx, proc := range done {
+		tooMany := s.retainCount > 0 && idx >= s.retainCount
+		tooOld := s.retainFor > 0 && time.Since(proc.endTime) > s.retainFor
+		if tooMany || tooOld {
+			log.Println("Forgetting finished process", proc.pid)
+			s.Processes.Put(proc.pid, nil)
+		}
+	}
+}
+
+// Reaps zombie children that aren't tracked by a Process,
+// such as orphans reparented to us as a subreaper
+func collectZombies() {
+	trackedMutex.Lock()
+	defer trackedMutex.Unlock()
+
+	procDirs, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		log.Println("WARN: Couldn't list /proc for zombies:", err)
+		return
+	}
+
+	ourPid := os.Getpid()
+	for _, dir := range procDirs {
+		pid, err := strconv.Atoi(dir.Name())
+		if err != nil || trackedPids[pid] {
+			continue
+		}
+
+		// fields after the command name are: state ppid ...
+		stat, err := ioutil.ReadFile("/proc/" + dir.Name() + "/stat")
+		if err != nil {
+			continue
+		}
+		commEnd := bytes.LastIndexByte(stat, ')')
+		if commEnd < 0 {
+			continue
+		}
+		fields := strings.Fields(string(stat[commEnd+1:]))
+		if len(fields) < 2 || fields[0] != "Z" || fields[1] != strconv.Itoa(ourPid) {
+			continue
+		}
+
+		var status syscall.WaitStatus
+		if _, err := syscall.Wait4(pid, &status, syscall.WNOHANG, nil); err != nil {
+			log.Println("WARN: Failed to reap zombie", pid, err)
+		} else {
+			log.Println("Reaped untracked zombie", pid)
+		}
+	}
+}
+
+// Reads a numbered array folder (such as exec arguments) into native strings
+func readArrayFolder(folder base.Folder) []string {
+	if folder == nil {
+		return nil
+	}
+
+	names := folder.Children()
+	values := make([]string, len(names))
+	for _, name := range names {
+		id, _ := strconv.Atoi(name)
+		if ent, ok := folder.Fetch(name); ok && id > 0 && id <= len(values) {
+			if str, ok := ent.(base.String); ok {
+				values[id-1] = str.Get()
+			}
+		}
+	}
+	return values
+}
+
+// Builds a numbered array folder from native strings
+func buildArrayFolder(in ...string) base.Folder {
+	folder := inmem.NewFolder("array")
+	for idx, str := range in {
+		folder.Put(strconv.Itoa(idx+1), inmem.NewString("", str))
 	}
+	return folder
 }
@@ -0,0 +1,231 @@
+package driver
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/stardustapp/dustgo/lib/base"
+)
+
+// Packs a subtree of the fs-root into one archive File
+// Formats are "tar" (the default), "tar.gz" and "zip".
+// Links are kept as links, but only if they stay within the root.
+// The archive is spooled to an unlinked temp file, then read lazily.
+func (s *Session) ExportArchiveImpl(input *ExportArchiveInput) base.File {
+	subPath, ok := s.resolvePath("ExportArchive():", input.Path)
+	if !ok {
+		return nil
+	}
+
+	format := input.Format
+	if format == "" {
+		format = "tar"
+	}
+	if format != "tar" && format != "tar.gz" && format != "zip" {
+		log.Println("WARN ExportArchive(): unknown archive format", format)
+		return nil
+	}
+
+	// the spool is unlinked right away, so it goes when the File does
+	spool, err := ioutil.TempFile("", "host-shell-export-")
+	if err != nil {
+		log.Println("WARN ExportArchive(): creating spool failed:", err)
+		return nil
+	}
+	os.Remove(spool.Name())
+
+	var archive archiveWriter
+	switch format {
+	case "tar":
+		archive = &tarArchive{writer: tar.NewWriter(spool)}
+	case "tar.gz":
+		gz := gzip.NewWriter(spool)
+		archive = &tarArchive{writer: tar.NewWriter(gz), gzip: gz}
+	case "zip":
+		archive = &zipArchive{writer: zip.NewWriter(spool)}
+	}
+
+	// entries are named relative to the given path, like `tar -C`
+	err = filepath.Walk(subPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name, _ := filepath.Rel(subPath, path)
+		if name == "." {
+			if info.IsDir() {
+				return nil
+			}
+			name = filepath.Base(path)
+		}
+		name = filepath.ToSlash(name)
+
+		switch {
+		case info.IsDir():
+			return archive.add(name+"/", info, "", nil)
+
+		case info.Mode().IsRegular():
+			file, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			return archive.add(name, info, "", file)
+
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if !s.linkTargetWithin(path, target) {
+				log.Println("WARN ExportArchive(): skipping link", path, "- it points outside of root")
+				return nil
+			}
+			return archive.add(name, info, target, nil)
+
+		default:
+			log.Println("WARN ExportArchive(): skipping special file", path)
+			return nil
+		}
+	})
+	if closeErr := archive.Close(); err == nil {
+		err = closeErr
+	}
+	var size int64
+	if err == nil {
+		size, err = spool.Seek(0, io.SeekCurrent)
+	}
+	if err != nil {
+		log.Println("WARN ExportArchive(): packing", subPath, "failed:", err)
+		spool.Close()
+		return nil
+	}
+
+	name := filepath.Base(subPath)
+	if subPath == s.fsPrefix {
+		name = "fs-root"
+	}
+	log.Println("ExportArchive(): packed", size, "bytes from", subPath)
+	return &spooledFile{name: name + "." + format, file: spool, size: size}
+}
+
+// A finished archive, read back out of its spool on demand
+// Nothing else holds the spool open, so it's closed once this is collected.
+type spooledFile struct {
+	name string
+	file *os.File
+	size int64
+}
+
+var _ base.File = (*spooledFile)(nil)
+
+func (e *spooledFile) Name() string {
+	return e.name
+}
+
+func (e *spooledFile) GetSize() int64 {
+	return e.size
+}
+
+func (e *spooledFile) Read(offset int64, numBytes int) []byte {
+	if offset < 0 || numBytes < 0 {
+		log.Println("WARN ExportArchive(): invalid range", offset, numBytes, "for", e.name)
+		return nil
+	}
+	if remaining := e.size - offset; remaining <= 0 {
+		return []byte{}
+	} else if int64(numBytes) > remaining {
+		numBytes = int(remaining)
+	}
+
+	data := make([]byte, numBytes)
+	n, err := e.file.ReadAt(data, offset)
+	if err != nil && err != io.EOF {
+		log.Println("WARN ExportArchive(): reading spool for", e.name, "failed:", err)
+	}
+	return data[:n]
+}
+
+func (e *spooledFile) Write(offset int64, data []byte) int {
+	return 0
+}
+
+func (e *spooledFile) Truncate(byteCount int64) bool {
+	return false
+}
+
+// Takes host entries one at a time, in walk order
+// Regular files come with their contents, links with their target
+type archiveWriter interface {
+	add(name string, info os.FileInfo, target string, content io.Reader) error
+	Close() error
+}
+
+type tarArchive struct {
+	writer *tar.Writer
+	gzip   *gzip.Writer
+}
+
+func (a *tarArchive) add(name string, info os.FileInfo, target string, content io.Reader) error {
+	header, err := tar.FileInfoHeader(info, target)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if err := a.writer.WriteHeader(header); err != nil {
+		return err
+	}
+	if content != nil {
+		// a file changing size mid-read would corrupt the archive
+		_, err = io.CopyN(a.writer, content, header.Size)
+	}
+	return err
+}
+
+func (a *tarArchive) Close() error {
+	err := a.writer.Close()
+	if a.gzip != nil {
+		if gzErr := a.gzip.Close(); err == nil {
+			err = gzErr
+		}
+	}
+	return err
+}
+
+type zipArchive struct {
+	writer *zip.Writer
+}
+
+func (a *zipArchive) add(name string, info os.FileInfo, target string, content io.Reader) error {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if content != nil {
+		header.Method = zip.Deflate
+	}
+
+	writer, err := a.writer.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	switch {
+	case target != "":
+		// zip keeps link targets as the entry's contents
+		_, err = io.WriteString(writer, target)
+	case content != nil:
+		_, err = io.Copy(writer, content)
+	}
+	return err
+}
+
+func (a *zipArchive) Close() error {
+	return a.writer.Close()
+}
@@ -0,0 +1,162 @@
+package driver
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stardustapp/dustgo/lib/base"
+	"github.com/stardustapp/dustgo/lib/inmem"
+)
+
+// How many matches to return, if the query doesn't say
+const defaultFindResults = 10000
+
+// Searches a subtree by name, type, size and mtime, using only stat info
+// Patterns with a slash match the whole relative path, others just the name.
+// Times are RFC3339, or a duration like "24h" meaning that long ago.
+func (s *Session) FindImpl(input *FindInput) base.Folder {
+	subPath, ok := s.resolvePath("Find():", input.Path)
+	if !ok {
+		return nil
+	}
+	query, err := parseFindQuery(input)
+	if err != nil {
+		log.Println("WARN Find(): bad query:", err)
+		return nil
+	}
+
+	results := inmem.NewFolder("results")
+	idx := 0
+	relRoot := strings.TrimPrefix(filepath.Clean("/"+input.Path), "/")
+	err = filepath.Walk(subPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// unreadable folders are left out, not fatal
+			log.Println("WARN Find(): skipping", path, "-", err)
+			return nil
+		}
+		if path == subPath {
+			return nil
+		}
+
+		rel, _ := filepath.Rel(subPath, path)
+		rel = filepath.ToSlash(rel)
+
+		if query.matches(rel, info) {
+			if idx >= query.maxResults {
+				log.Println("WARN Find(): stopping at", idx, "results in", subPath)
+				return errFindLimit
+			}
+			idx += 1
+			results.Put(strconv.Itoa(idx), buildFileStat(filepath.ToSlash(filepath.Join(relRoot, rel)), info))
+		}
+
+		// don't even list folders past the depth limit
+		depth := strings.Count(rel, "/") + 1
+		if info.IsDir() && query.maxDepth > 0 && depth >= query.maxDepth {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil && err != errFindLimit {
+		log.Println("WARN Find(): searching", subPath, "failed:", err)
+		return nil
+	}
+
+	log.Println("Find(): found", idx, "matches in", subPath)
+	return results
+}
+
+var errFindLimit = errors.New("result limit reached")
+
+type findQuery struct {
+	pattern    string
+	entryType  string
+	maxDepth   int
+	maxResults int
+	minSize    int64
+	maxSize    int64
+	after      time.Time
+	before     time.Time
+}
+
+func parseFindQuery(input *FindInput) (*findQuery, error) {
+	query := &findQuery{
+		pattern:    input.Pattern,
+		entryType:  strings.ToLower(input.Type),
+		maxResults: defaultFindResults,
+		maxSize:    -1,
+	}
+	if _, err := filepath.Match(query.pattern, ""); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if input.MaxDepth != "" {
+		if query.maxDepth, err = strconv.Atoi(input.MaxDepth); err != nil {
+			return nil, err
+		}
+	}
+	if input.MaxResults != "" {
+		if query.maxResults, err = strconv.Atoi(input.MaxResults); err != nil {
+			return nil, err
+		}
+	}
+	if input.MinSize != "" {
+		if query.minSize, err = strconv.ParseInt(input.MinSize, 10, 64); err != nil {
+			return nil, err
+		}
+	}
+	if input.MaxSize != "" {
+		if query.maxSize, err = strconv.ParseInt(input.MaxSize, 10, 64); err != nil {
+			return nil, err
+		}
+	}
+	if query.after, err = parseFindTime(input.ModifiedAfter); err != nil {
+		return nil, err
+	}
+	if query.before, err = parseFindTime(input.ModifiedBefore); err != nil {
+		return nil, err
+	}
+	return query, nil
+}
+
+func parseFindTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if ago, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-ago), nil
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+func (q *findQuery) matches(rel string, info os.FileInfo) bool {
+	if q.pattern != "" {
+		name := filepath.Base(rel)
+		if strings.Contains(q.pattern, "/") {
+			name = rel
+		}
+		if ok, _ := filepath.Match(q.pattern, name); !ok {
+			return false
+		}
+	}
+
+	if q.entryType != "" && q.entryType != strings.ToLower(fileTypeOf(info.Mode())) {
+		return false
+	}
+	if info.Size() < q.minSize || (q.maxSize >= 0 && info.Size() > q.maxSize) {
+		return false
+	}
+	if !q.after.IsZero() && !info.ModTime().After(q.after) {
+		return false
+	}
+	if !q.before.IsZero() && !info.ModTime().Before(q.before) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,76 @@
+package driver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/stardustapp/dustgo/lib/base"
+	"github.com/stardustapp/dustgo/lib/inmem"
+)
+
+// Computes SHA-256 over a file, or every file in a subtree
+// Returns a numbered manifest, ordered by path. Links aren't followed.
+func (s *Session) HashImpl(path string) base.Folder {
+	subPath, ok := s.resolvePath("Hash():", path)
+	if !ok {
+		return nil
+	}
+
+	manifest := inmem.NewFolder("manifest")
+	idx := 0
+	relRoot := strings.TrimPrefix(filepath.Clean("/"+path), "/")
+	err := filepath.Walk(subPath, func(walkPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		hash, err := hashHostFile(walkPath)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(subPath, walkPath)
+		idx += 1
+		manifest.Put(strconv.Itoa(idx), &ManifestEntry{
+			Path:  filepath.ToSlash(filepath.Join(relRoot, rel)),
+			Hash:  hash,
+			Size:  strconv.FormatInt(info.Size(), 10),
+			Mtime: info.ModTime().UTC().Format(time.RFC3339Nano),
+		})
+		return nil
+	})
+	if err != nil {
+		log.Println("WARN Hash(): hashing", subPath, "failed:", err)
+		return nil
+	}
+
+	log.Println("Hash(): hashed", idx, "files in", subPath)
+	return manifest
+}
+
+func hashHostFile(path string) (string, error) {
+	file, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	return hashReader(file)
+}
+
+func hashReader(reader io.Reader) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, reader); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
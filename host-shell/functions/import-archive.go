@@ -0,0 +1,289 @@
+package driver
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/stardustapp/dustgo/lib/base"
+	"github.com/stardustapp/dustgo/lib/inmem"
+)
+
+// Link targets are stored as zip contents, but never this big
+const maxZipLinkTarget = 4096
+
+// Unpacks an uploaded archive into a folder within the fs-root
+// The format is sniffed from the archive when not given.
+// Every entry goes through hostFolder.Put, so the usual checks apply,
+// and entries which try to climb out with `..` are refused outright.
+func (s *Session) ImportArchiveImpl(input *ImportArchiveInput) *OperationReport {
+	if input.Archive == nil {
+		return &OperationReport{Status: "Failed: no archive given"}
+	}
+	destPath, ok := s.resolvePath("ImportArchive():", input.Destination)
+	if !ok {
+		return &OperationReport{Status: "Failed: invalid destination path"}
+	}
+
+	dest := s.getRoot().(*hostFolder)
+	if destPath != s.fsPrefix {
+		if dest, ok = ensureFolder(s.parentOf(destPath), filepath.Base(destPath)); !ok {
+			return &OperationReport{Status: "Failed: couldn't create destination"}
+		}
+	}
+
+	format := input.Format
+	if format == "" {
+		format = sniffArchiveFormat(input.Archive)
+	}
+
+	var unpacked []string
+	var rejected int
+	relRoot := strings.TrimPrefix(filepath.Clean("/"+input.Destination), "/")
+	unpack := func(name string, mode os.FileMode, target string, size int64, content io.Reader) {
+		if relPath, ok := unpackEntry(dest, name, mode, target, size, content); ok {
+			unpacked = append(unpacked, path.Join(relRoot, relPath))
+		} else {
+			rejected++
+		}
+	}
+
+	var err error
+	archive := &entryReader{file: input.Archive}
+	switch format {
+	case "tar":
+		err = readTar(archive, unpack)
+	case "tar.gz":
+		var gz *gzip.Reader
+		if gz, err = gzip.NewReader(archive); err == nil {
+			err = readTar(gz, unpack)
+			gz.Close()
+		}
+	case "zip":
+		err = readZip(archive, input.Archive.GetSize(), unpack)
+	default:
+		err = errors.New("unknown archive format " + format)
+	}
+
+	status := "Ok"
+	if err != nil {
+		log.Println("WARN ImportArchive(): reading archive failed:", err)
+		status = "Failed: " + err.Error()
+	} else if rejected > 0 {
+		status = "Failed: " + strconv.Itoa(rejected) + " entries weren't unpacked"
+	}
+	log.Println("ImportArchive(): unpacked", len(unpacked), "entries to", destPath)
+	return &OperationReport{
+		Status: status,
+		Count:  strconv.Itoa(len(unpacked)),
+		Paths:  buildArrayFolder(unpacked...),
+	}
+}
+
+// Guesses the format from the archive's magic bytes
+func sniffArchiveFormat(archive base.File) string {
+	magic := archive.Read(0, 4)
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")), bytes.HasPrefix(magic, []byte("PK\x05\x06")):
+		return "zip"
+	case bytes.HasPrefix(magic, []byte("\x1f\x8b")):
+		return "tar.gz"
+	default:
+		return "tar"
+	}
+}
+
+func readTar(src io.Reader, unpack func(string, os.FileMode, string, int64, io.Reader)) error {
+	reader := tar.NewReader(src)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		mode := os.FileMode(header.Mode).Perm()
+		switch header.Typeflag {
+		case tar.TypeDir:
+			unpack(header.Name, mode|os.ModeDir, "", 0, nil)
+		case tar.TypeReg, tar.TypeRegA:
+			unpack(header.Name, mode, "", header.Size, reader)
+		case tar.TypeSymlink:
+			unpack(header.Name, mode|os.ModeSymlink, header.Linkname, 0, nil)
+		case tar.TypeXGlobalHeader:
+			// only metadata
+		default:
+			log.Printf("WARN ImportArchive(): skipping %q - unsupported entry type %q", header.Name, header.Typeflag)
+			unpack(header.Name, os.ModeIrregular, "", 0, nil)
+		}
+	}
+}
+
+func readZip(src io.ReaderAt, size int64, unpack func(string, os.FileMode, string, int64, io.Reader)) error {
+	reader, err := zip.NewReader(src, size)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range reader.File {
+		content, err := file.Open()
+		if err != nil {
+			return err
+		}
+
+		mode := file.Mode()
+		if mode&os.ModeSymlink != 0 {
+			target, err := ioutil.ReadAll(io.LimitReader(content, maxZipLinkTarget))
+			if err != nil {
+				content.Close()
+				return err
+			}
+			unpack(file.Name, mode, string(target), 0, nil)
+		} else {
+			unpack(file.Name, mode, "", int64(file.UncompressedSize64), content)
+		}
+		if err := content.Close(); err != nil {
+			return err // checksum mismatch
+		}
+	}
+	return nil
+}
+
+// Writes one archive entry below the destination, creating parents as needed
+// Returns the entry's cleaned path, relative to the destination
+func unpackEntry(dest *hostFolder, name string, mode os.FileMode, target string, size int64, content io.Reader) (string, bool) {
+	parts, ok := splitArchiveName(name)
+	if !ok {
+		log.Printf("WARN ImportArchive(): rejecting entry %q - it would land outside the destination", name)
+		return "", false
+	}
+
+	folder := dest
+	for _, part := range parts[:len(parts)-1] {
+		if folder, ok = ensureFolder(folder, part); !ok {
+			return "", false
+		}
+	}
+	leaf := parts[len(parts)-1]
+	relPath := strings.Join(parts, "/")
+
+	switch {
+	case mode.IsDir():
+		_, ok = ensureFolder(folder, leaf)
+
+	case mode.IsRegular():
+		ok = folder.Put(leaf, &streamedFile{name: leaf, size: size, reader: content})
+		if ok && mode.Perm() != 0 {
+			// executables should stay executable
+			if file, err := os.OpenFile(filepath.Join(folder.prefix, leaf), os.O_RDONLY|syscall.O_NOFOLLOW, 0); err == nil {
+				file.Chmod(mode.Perm())
+				file.Close()
+			}
+		}
+
+	case mode&os.ModeSymlink != 0:
+		ok = folder.Put(leaf, inmem.NewLink(leaf, target))
+
+	default:
+		ok = false
+	}
+	return relPath, ok
+}
+
+// Cleans up an entry name, refusing any that aren't plain relative paths
+func splitArchiveName(name string) ([]string, bool) {
+	if strings.HasPrefix(name, "/") {
+		return nil, false
+	}
+
+	var parts []string
+	for _, part := range strings.Split(name, "/") {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			return nil, false
+		}
+		parts = append(parts, part)
+	}
+	return parts, len(parts) > 0
+}
+
+// Descends into a child folder, making it first if it's missing
+func ensureFolder(parent *hostFolder, name string) (*hostFolder, bool) {
+	if entry, ok := parent.Fetch(name); ok {
+		folder, isFolder := entry.(*hostFolder)
+		if !isFolder {
+			log.Println("WARN ImportArchive():", name, "in", parent.prefix, "already exists and isn't a folder")
+		}
+		return folder, isFolder
+	}
+
+	if !parent.Put(name, inmem.NewFolder(name)) {
+		return nil, false
+	}
+	return &hostFolder{
+		name:    name,
+		session: parent.session,
+		prefix:  filepath.Join(parent.prefix, name),
+	}, true
+}
+
+// Presents the current archive entry as a File that's read front to back
+// Put streams through it with entryReader, so nothing is held in memory
+type streamedFile struct {
+	name   string
+	size   int64
+	reader io.Reader
+	offset int64
+}
+
+var _ base.File = (*streamedFile)(nil)
+
+func (e *streamedFile) Name() string {
+	return e.name
+}
+
+func (e *streamedFile) GetSize() int64 {
+	return e.size
+}
+
+func (e *streamedFile) Read(offset int64, numBytes int) []byte {
+	if offset != e.offset {
+		log.Println("WARN ImportArchive(): can't seek within", e.name)
+		return nil
+	}
+	if remaining := e.size - offset; int64(numBytes) > remaining {
+		numBytes = int(remaining)
+	}
+
+	// a short read means the archive is broken, so the write gets abandoned
+	buf := make([]byte, numBytes)
+	n, err := io.ReadFull(e.reader, buf)
+	if err != nil {
+		log.Println("WARN ImportArchive(): reading", e.name, "failed:", err)
+		return nil
+	}
+	e.offset += int64(n)
+	return buf
+}
+
+func (e *streamedFile) Write(offset int64, data []byte) int {
+	return 0
+}
+
+func (e *streamedFile) Truncate(byteCount int64) bool {
+	return false
+}
@@ -0,0 +1,8 @@
+package driver
+
+import "syscall"
+
+// Forcibly stops the process and anything else in its group
+func (p *Process) KillImpl() string {
+	return p.sendSignal(syscall.SIGKILL)
+}
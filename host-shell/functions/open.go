@@ -1,20 +1,30 @@
 package driver
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
+	"unsafe"
 
 	"github.com/stardustapp/dustgo/lib/base"
 	"github.com/stardustapp/dustgo/lib/extras"
 	"github.com/stardustapp/dustgo/lib/inmem"
+	"github.com/stardustapp/dustgo/lib/skylink"
 	"github.com/stardustapp/dustgo/lib/toolbox"
 	//"github.com/erikdubbelboer/gspt"
 )
 
+// How many finished processes to keep, if the mount doesn't say
+const defaultProcessRetention = 100
+
 // Presents a faithful representation of the underlying host filesystem
 // Exposes a simple File/Folder-only namesystem
 // Also allows arbitrary POSIX process execution
@@ -32,13 +42,65 @@ func (r *Root) OpenImpl(opts *MountOpts) *Session {
 	sessionPath := fmt.Sprintf(":9234/pub/sessions/%s", sessionId)
 	sessionUri, _ := toolbox.SelfURI(sessionPath)
 
+	// Mounts are read-only unless writing is explicitly enabled
+	// "create-only" allows adding new names but never changing existing ones
+	writeMode := "no"
+	switch opts.EnableWriting {
+	case "yes", "create-only":
+		writeMode = opts.EnableWriting
+	case "", "no":
+	default:
+		log.Println("WARN: Unknown enable-writing", opts.EnableWriting, "- mounting read-only")
+	}
+
+	// Resolve the root up front, so containment checks compare real paths
+	fsPrefix, err := filepath.EvalSymlinks(filepath.Clean(opts.FsRootPath))
+	if err != nil {
+		log.Println("WARN: Couldn't resolve fs-root-path", opts.FsRootPath, err)
+		return nil
+	}
+
 	session := &Session{
-		fsPrefix: filepath.Clean(opts.FsRootPath),
-		URI:      sessionUri,
+		fsPrefix:     fsPrefix,
+		shellEnabled: opts.EnableShell == "yes",
+		writeMode:    writeMode,
+		URI:          sessionUri,
+		Processes:    inmem.NewFolder("processes"),
+	}
+
+	// Finished processes are kept around for a while
+	// With no retention configured, only the most recent are kept
+	if opts.ProcessRetentionCount != "" {
+		if session.retainCount, err = strconv.Atoi(opts.ProcessRetentionCount); err != nil {
+			log.Println("WARN: Invalid process-retention-count", opts.ProcessRetentionCount)
+		}
+	}
+	if opts.ProcessRetentionMinutes != "" {
+		minutes, err := strconv.Atoi(opts.ProcessRetentionMinutes)
+		if err != nil {
+			log.Println("WARN: Invalid process-retention-minutes", opts.ProcessRetentionMinutes)
+		}
+		session.retainFor = time.Duration(minutes) * time.Minute
+	}
+	if session.retainCount <= 0 && session.retainFor <= 0 {
+		session.retainCount = defaultProcessRetention
+	}
+
+	// Executables can be limited to a known list
+	if session.shellEnabled && opts.AllowedExecutables != nil {
+		session.allowedExecs = make([]string, 0)
+		for _, name := range readArrayFolder(opts.AllowedExecutables) {
+			session.allowedExecs = append(session.allowedExecs, session.resolveAllowedExec(name))
+		}
+		log.Println("Session allows executing", session.allowedExecs)
 	}
 	session.FsRoot = session.getRoot()
 	log.Printf("built session %+v", session)
 
+	if session.shellEnabled {
+		session.startReaping()
+	}
+
 	if ok := r.Sessions.Put(sessionId, session); !ok {
 		log.Println("Session store rejected us :(")
 		return nil
@@ -54,6 +116,78 @@ func (s *Session) getRoot() base.Folder {
 	}
 }
 
+// Checks a mutation against the session's enable-writing mode
+// Logs the reason whenever the mutation isn't allowed
+func (s *Session) allowsWrite(op, path string, exists bool) bool {
+	switch s.writeMode {
+	case "yes":
+		return true
+	case "create-only":
+		if !exists {
+			return true
+		}
+		log.Println("WARN:", op, "rejected for", path, "- mount is create-only and it already exists")
+	default:
+		log.Println("WARN:", op, "rejected for", path, "- mount is read-only")
+	}
+	return false
+}
+
+// Checks that a clean path is either the root itself or within it
+func pathWithin(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, "../")
+}
+
+// Resolves a slash-separated path within the fs-root into a host path
+// The final component is left unresolved, in case it's a link
+func (s *Session) resolvePath(op, path string) (string, bool) {
+	if strings.ContainsAny(path, "\r\n\x00") {
+		log.Printf("WARN %s path %q has restricted chars", op, path)
+		return "", false
+	}
+
+	subPath := filepath.Join(s.fsPrefix, filepath.Clean("/"+path))
+	if subPath == s.fsPrefix {
+		return subPath, true
+	}
+
+	realDir, err := filepath.EvalSymlinks(filepath.Dir(subPath))
+	if err != nil {
+		log.Println("WARN", op, "resolving", subPath, "failed:", err)
+		return "", false
+	}
+	if !pathWithin(s.fsPrefix, realDir) {
+		log.Println("WARN", op, subPath, "really is in", realDir, "- outside of root", s.fsPrefix)
+		return "", false
+	}
+	return filepath.Join(realDir, filepath.Base(subPath)), true
+}
+
+// Fetches the entry at a slash-separated path within the fs-root
+func (s *Session) fetchPath(op, path string) (base.Entry, bool) {
+	subPath, ok := s.resolvePath(op, path)
+	if !ok {
+		return nil, false
+	}
+	if subPath == s.fsPrefix {
+		return s.getRoot(), true
+	}
+	return s.parentOf(subPath).Fetch(filepath.Base(subPath))
+}
+
+// Presents the folder containing a resolved host path
+func (s *Session) parentOf(subPath string) *hostFolder {
+	return &hostFolder{
+		name:    filepath.Base(filepath.Dir(subPath)),
+		session: s,
+		prefix:  filepath.Dir(subPath),
+	}
+}
+
 // Persists as a Folder from the host OS
 // Presents as a dynamic name tree
 type hostFolder struct {
@@ -68,7 +202,53 @@ func (e *hostFolder) Name() string {
 	return e.name
 }
 
+// Makes sure this folder still really lives within the session root,
+// in case one of its parents has been swapped out for a symlink
+func (e *hostFolder) checkPrefix(op string) bool {
+	realPrefix, err := filepath.EvalSymlinks(e.prefix)
+	if err != nil {
+		log.Println("WARN", op, "resolving", e.prefix, "failed:", err)
+		return false
+	}
+	if !pathWithin(e.session.fsPrefix, realPrefix) {
+		log.Println("WARN", op, e.prefix, "really is", realPrefix, "- outside of root", e.session.fsPrefix)
+		return false
+	}
+	return true
+}
+
+// Builds the host path for a direct child of this folder
+func (e *hostFolder) childPath(op, name string) (string, bool) {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "\r\n\x00/") {
+		log.Printf("WARN %s child name %q has restricted chars", op, name)
+		return "", false
+	}
+	if !e.checkPrefix(op) {
+		return "", false
+	}
+	return filepath.Join(e.prefix, name), true
+}
+
+// Decides where a symlink at linkPath with the given target would lead,
+// and whether that's still within the session root
+func (s *Session) linkTargetWithin(linkPath, target string) bool {
+	dest := target
+	if !filepath.IsAbs(target) {
+		dest = filepath.Join(filepath.Dir(linkPath), target)
+	}
+
+	// chains of links have to be followed all the way, when they exist
+	if realDest, err := filepath.EvalSymlinks(dest); err == nil {
+		dest = realDest
+	}
+	return pathWithin(s.fsPrefix, filepath.Clean(dest))
+}
+
 func (e *hostFolder) Children() []string {
+	if !e.checkPrefix("Children():") {
+		return []string{"error"}
+	}
+
 	files, err := ioutil.ReadDir(e.prefix)
 	if err != nil {
 		log.Println("WARN Children():", err)
@@ -83,26 +263,32 @@ func (e *hostFolder) Children() []string {
 }
 
 func (e *hostFolder) Fetch(name string) (entry base.Entry, ok bool) {
-	if strings.ContainsAny(name, "\r\n\x00/") {
-		log.Printf("WARN Fetch(): child name %q has restricted chars", name)
+	subPath, ok := e.childPath("Fetch():", name)
+	if !ok {
 		return nil, false
 	}
 
-	subPath := filepath.Clean(filepath.Join(e.prefix, name))
-	if !strings.HasPrefix(subPath, e.prefix) {
-		log.Println("WARN Fetch(): subpath", subPath, "isn't in prefix", e.prefix)
-		return nil, false
-	}
-
-	// figure out what the path refers to
-	fileInfo, err := os.Stat(subPath)
+	// figure out what the path refers to, without following links
+	fileInfo, err := os.Lstat(subPath)
 	if err != nil {
 		log.Println("WARN Fetch(): stat", subPath, "failed:", err)
 		return nil, false
 	}
 
-	// we support files and folders only, try 'em
+	// we support files, folders, and links, try 'em
 	switch {
+	case fileInfo.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(subPath)
+		if err != nil {
+			log.Println("WARN Fetch(): readlink", subPath, "failed:", err)
+			return nil, false
+		}
+		if !e.session.linkTargetWithin(subPath, target) {
+			log.Println("WARN Fetch(): link", subPath, "points outside of root:", target)
+			return nil, false
+		}
+		return inmem.NewLink(name, target), true
+
 	case fileInfo.Mode().IsDir():
 		return &hostFolder{
 			name:    name,
@@ -111,13 +297,11 @@ func (e *hostFolder) Fetch(name string) (entry base.Entry, ok bool) {
 		}, true
 
 	case fileInfo.Mode().IsRegular():
-		data, err := ioutil.ReadFile(subPath)
-		if err != nil {
-			log.Println("WARN Fetch() ReadFile(", subPath, ":", err)
-			return nil, false
-		}
-
-		return inmem.NewFile(name, data), true
+		return &hostFile{
+			name:    name,
+			session: e.session,
+			path:    subPath,
+		}, true
 
 	default:
 		log.Println("WARN Fetch():", subPath, "has weird mode:", fileInfo.Mode())
@@ -127,14 +311,14 @@ func (e *hostFolder) Fetch(name string) (entry base.Entry, ok bool) {
 
 // create/replace/remove a child with a new inode
 func (e *hostFolder) Put(name string, entry base.Entry) (ok bool) {
-	if strings.ContainsAny(name, "\r\n\x00/") {
-		log.Printf("WARN Put(): child name %q has restricted chars", name)
-		return false
-	}
+	return e.putRecorded(name, entry, "", nil)
+}
 
-	subPath := filepath.Clean(filepath.Join(e.prefix, name))
-	if !strings.HasPrefix(subPath, e.prefix) {
-		log.Println("WARN Put(): subpath", subPath, "isn't in prefix", e.prefix)
+// Does the work of Put, appending the path of everything written to the
+// list when one is given, with folders ahead of their contents
+func (e *hostFolder) putRecorded(name string, entry base.Entry, path string, written *[]string) (ok bool) {
+	subPath, ok := e.childPath("Put():", name)
+	if !ok {
 		return false
 	}
 
@@ -153,6 +337,9 @@ func (e *hostFolder) Put(name string, entry base.Entry) (ok bool) {
 	// handle deletions
 	if entry == nil {
 		if fileExists {
+			if !e.session.allowsWrite("Put(): delete", subPath, true) {
+				return false
+			}
 			log.Println("WARN Put(): DELETING", subPath)
 			if err := os.Remove(subPath); err != nil {
 				log.Println("WARN Put(): FAILED to delete", subPath, err)
@@ -162,6 +349,10 @@ func (e *hostFolder) Put(name string, entry base.Entry) (ok bool) {
 		return true
 	}
 
+	if !e.session.allowsWrite("Put(): write", subPath, fileExists) {
+		return false
+	}
+
 	// figure out what we got
 	switch entry := entry.(type) {
 
@@ -169,14 +360,39 @@ func (e *hostFolder) Put(name string, entry base.Entry) (ok bool) {
 	//case *hostFolder:
 
 	case base.Folder:
-		// don't worry about contents (unlike redis)
-		// just try making it
+		// copying a host folder into itself would never finish
+		if source, ok := entry.(*hostFolder); ok && pathWithin(source.prefix, subPath) {
+			log.Println("WARN Put(): refusing to copy", source.prefix, "into itself at", subPath)
+			return false
+		}
+
 		err := os.Mkdir(subPath, 0755)
 		if err != nil {
 			log.Println("WARN Put(): FAILED to mkdir", subPath, err)
 			return false
 		}
 		log.Println("WARN Put(): SUCCESSFULLY MKDIRD", subPath)
+		if written != nil {
+			*written = append(*written, path)
+		}
+
+		// recursively copy entire folder to disk
+		dest := &hostFolder{
+			name:    name,
+			session: e.session,
+			prefix:  subPath,
+		}
+		copiedAll := true
+		for _, child := range entry.Children() {
+			childEnt, ok := entry.Fetch(child)
+			if !ok {
+				log.Println("WARN Put(): Failed to get child", child, "of", name)
+				copiedAll = false
+			} else if !dest.putRecorded(child, childEnt, path+"/"+child, written) {
+				copiedAll = false
+			}
+		}
+		return copiedAll
 
 	case base.File:
 		if statErr == nil && !fileInfo.Mode().IsRegular() {
@@ -184,21 +400,435 @@ func (e *hostFolder) Put(name string, entry base.Entry) (ok bool) {
 			return false
 		}
 
-		size := entry.GetSize()
-		data := entry.Read(0, int(size))
+		// replacements keep the existing file's mode
+		mode := os.FileMode(0644)
+		var replacing os.FileInfo
+		if fileExists {
+			mode = fileInfo.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+			replacing = fileInfo
+		}
+
+		// stream it over, so big files never sit in memory
+		written, err := writeFileAtomic(subPath, &entryReader{file: entry}, mode, replacing)
+		if err != nil {
+			log.Println("WARN Put(): FAILED to write", written, "bytes to", subPath, err)
+			return false
+		}
+		log.Println("WARN Put(): SUCCESSFULLY WROTE", written, "bytes to", subPath)
+
+	case base.Link:
+		target := entry.Target()
+		if !e.session.linkTargetWithin(subPath, target) {
+			log.Println("WARN Put(): link", subPath, "would point outside of root:", target)
+			return false
+		}
 
-		// write it
-		err := ioutil.WriteFile(subPath, data, 0644)
+		err := os.Symlink(target, subPath)
 		if err != nil {
-			log.Println("WARN Put(): FAILED to write", len(data), "bytes to", subPath, err)
+			log.Println("WARN Put(): FAILED to link", subPath, "to", target, err)
 			return false
 		}
-		log.Println("WARN Put(): SUCCESSFULLY WROTE", len(data), "bytes to", subPath)
+		log.Println("WARN Put(): SUCCESSFULLY LINKED", subPath, "to", target)
 
 	default:
 		log.Println("WARN Put(): unsupported entry for", subPath)
 		return false
 	}
 
+	if written != nil {
+		*written = append(*written, path)
+	}
+	return true
+}
+
+// Writes a whole file alongside its destination, then renames it into place
+// Readers only ever see the old contents or the new, never a partial write.
+// If a file is being replaced, its ownership is carried over when possible.
+func writeFileAtomic(path string, src io.Reader, mode os.FileMode, replacing os.FileInfo) (int64, error) {
+	temp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return 0, err
+	}
+	tempPath := temp.Name()
+
+	written, err := io.Copy(temp, src)
+	if err == nil {
+		err = temp.Sync()
+	}
+	if err == nil {
+		err = temp.Chmod(mode)
+	}
+	if err == nil && replacing != nil {
+		if sys, ok := replacing.Sys().(*syscall.Stat_t); ok {
+			if chownErr := temp.Chown(int(sys.Uid), int(sys.Gid)); chownErr != nil {
+				log.Println("WARN: Couldn't keep ownership of", path, chownErr)
+			}
+		}
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tempPath, path)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return written, err
+	}
+
+	// make the rename itself durable too
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return written, nil
+}
+
+// Persists as a File from the host OS
+// Reads and writes byte ranges on demand, never the whole file
+type hostFile struct {
+	name    string
+	session *Session
+	path    string
+}
+
+var _ base.File = (*hostFile)(nil)
+
+func (e *hostFile) Name() string {
+	return e.name
+}
+
+// Opens the underlying file, refusing to follow a link that has
+// replaced it, or a parent folder that has moved outside of the root
+func (e *hostFile) open(op string, flag int) (*os.File, bool) {
+	realDir, err := filepath.EvalSymlinks(filepath.Dir(e.path))
+	if err != nil || !pathWithin(e.session.fsPrefix, realDir) {
+		log.Println("WARN", op, e.path, "is no longer within root", e.session.fsPrefix, err)
+		return nil, false
+	}
+
+	file, err := os.OpenFile(e.path, flag|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		log.Println("WARN", op, "open", e.path, "failed:", err)
+		return nil, false
+	}
+	return file, true
+}
+
+func (e *hostFile) GetSize() int64 {
+	fileInfo, err := os.Lstat(e.path)
+	if err != nil {
+		log.Println("WARN GetSize(): stat", e.path, "failed:", err)
+		return 0
+	}
+	return fileInfo.Size()
+}
+
+func (e *hostFile) Read(offset int64, numBytes int) (data []byte) {
+	if offset < 0 || numBytes < 0 {
+		log.Println("WARN Read(): invalid range", offset, numBytes, "for", e.path)
+		return nil
+	}
+	file, ok := e.open("Read():", os.O_RDONLY)
+	if !ok {
+		return nil
+	}
+	defer file.Close()
+
+	// never allocate more than the file could give back
+	fileInfo, err := file.Stat()
+	if err != nil {
+		log.Println("WARN Read(): stat", e.path, "failed:", err)
+		return nil
+	}
+	if remaining := fileInfo.Size() - offset; remaining <= 0 {
+		return []byte{}
+	} else if int64(numBytes) > remaining {
+		numBytes = int(remaining)
+	}
+
+	data = make([]byte, numBytes)
+	n, err := file.ReadAt(data, offset)
+	if err != nil && err != io.EOF {
+		log.Println("WARN Read(): pread", e.path, "failed:", err)
+	}
+	return data[:n]
+}
+
+func (e *hostFile) Write(offset int64, data []byte) (numBytes int) {
+	if !e.session.allowsWrite("Write()", e.path, true) {
+		return 0
+	}
+	file, ok := e.open("Write():", os.O_WRONLY)
+	if !ok {
+		return 0
+	}
+	defer file.Close()
+
+	numBytes, err := file.WriteAt(data, offset)
+	if err != nil {
+		log.Println("WARN Write(): pwrite", e.path, "failed:", err)
+	}
+	return
+}
+
+func (e *hostFile) Truncate(byteCount int64) (ok bool) {
+	if !e.session.allowsWrite("Truncate()", e.path, true) {
+		return false
+	}
+	file, ok := e.open("Truncate():", os.O_WRONLY)
+	if !ok {
+		return false
+	}
+	defer file.Close()
+
+	if err := file.Truncate(byteCount); err != nil {
+		log.Println("WARN Truncate():", e.path, "failed:", err)
+		return false
+	}
 	return true
 }
+
+// Adapts any File entry into an io.Reader, one range at a time
+type entryReader struct {
+	file   base.File
+	offset int64
+}
+
+func (r *entryReader) Read(p []byte) (n int, err error) {
+	if r.offset >= r.file.GetSize() {
+		return 0, io.EOF
+	}
+
+	data := r.file.Read(r.offset, len(p))
+	if len(data) == 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	n = copy(p, data)
+	r.offset += int64(n)
+	return n, nil
+}
+
+// Random access too, for formats that keep their index at the end
+func (r *entryReader) ReadAt(p []byte, offset int64) (n int, err error) {
+	for n < len(p) {
+		data := r.file.Read(offset+int64(n), len(p)-n)
+		if len(data) == 0 {
+			return n, io.EOF
+		}
+		n += copy(p[n:], data)
+	}
+	return n, nil
+}
+
+///////////////////////////////////////////
+// inotify-backed subscribe() impl
+// Watches every folder down to the subscription's depth
+
+// Events that can change what a subscriber sees
+const inotifyMask = syscall.IN_CREATE | syscall.IN_MOVED_TO |
+	syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE | syscall.IN_ATTRIB |
+	syscall.IN_DELETE | syscall.IN_MOVED_FROM |
+	syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF |
+	syscall.IN_DONT_FOLLOW | syscall.IN_ONLYDIR
+
+type inotifySub struct {
+	folder *hostFolder
+	sub    *skylink.Subscription
+	fd     int
+
+	watches map[int32]*watchedDir
+}
+
+type watchedDir struct {
+	wd     int32
+	path   string // relative to the subscribed folder
+	prefix string // absolute host path
+	height int    // remaining children depths
+}
+
+func (e *hostFolder) Subscribe(s *skylink.Subscription) (err error) {
+	if !e.checkPrefix("Subscribe():") {
+		return errors.New("folder isn't within the session root")
+	}
+
+	fd, err := syscall.InotifyInit1(syscall.IN_NONBLOCK | syscall.IN_CLOEXEC)
+	if err != nil {
+		return errors.New("inotify init error: " + err.Error())
+	}
+	// wrapping lets the runtime poll the fd, and unblocks reads on close
+	events := os.NewFile(uintptr(fd), "inotify")
+	log.Println("Starting host-shell inotify sub on", e.prefix)
+
+	state := &inotifySub{
+		folder:  e,
+		sub:     s,
+		fd:      fd,
+		watches: make(map[int32]*watchedDir),
+	}
+
+	go func(stopC <-chan struct{}) {
+		<-stopC
+		log.Println("closing inotify sub on", e.prefix)
+		events.Close()
+	}(s.StopC)
+
+	go func() {
+		defer log.Println("stopped inotify sub loop on", e.prefix)
+		defer s.Close()
+
+		s.SendNotification("Added", "", inmem.NewFolder(e.name))
+		state.load("", e.prefix, s.MaxDepth)
+		s.SendNotification("Ready", "", nil)
+
+		buf := make([]byte, 64*1024)
+		for {
+			n, err := events.Read(buf)
+			if err != nil {
+				log.Println("inotify sub read ended:", err)
+				return
+			}
+			if done := state.processEvents(buf[:n]); done {
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Watches a folder and sends its existing children, recursively
+// Watching happens first, so nothing created meanwhile is missed
+func (state *inotifySub) load(path, prefix string, height int) {
+	if height <= 0 {
+		return
+	}
+
+	wd, err := syscall.InotifyAddWatch(state.fd, prefix, inotifyMask)
+	if err != nil {
+		log.Println("WARN: Couldn't watch", prefix, err)
+		return
+	}
+	state.watches[int32(wd)] = &watchedDir{
+		wd:     int32(wd),
+		path:   path,
+		prefix: prefix,
+		height: height,
+	}
+
+	files, err := ioutil.ReadDir(prefix)
+	if err != nil {
+		log.Println("WARN: Couldn't list", prefix, err)
+		return
+	}
+	for _, file := range files {
+		childPath := joinSubPath(path, file.Name())
+		if entry := state.entryFor(prefix, file.Name()); entry != nil {
+			state.sub.SendNotification("Added", childPath, entry)
+		}
+		if file.IsDir() {
+			state.load(childPath, filepath.Join(prefix, file.Name()), height-1)
+		}
+	}
+}
+
+// Stops watching a folder that went away, and everything under it
+func (state *inotifySub) unload(path string) {
+	for wd, dir := range state.watches {
+		if dir.path == path || strings.HasPrefix(dir.path, path+"/") {
+			syscall.InotifyRmWatch(state.fd, uint32(wd))
+			delete(state.watches, wd)
+		}
+	}
+}
+
+// Builds the shallow entry that's sent along with a notification
+// Returns nil if the entry is already gone, or isn't presentable
+func (state *inotifySub) entryFor(prefix, name string) base.Entry {
+	parent := &hostFolder{
+		name:    filepath.Base(prefix),
+		session: state.folder.session,
+		prefix:  prefix,
+	}
+	entry, ok := parent.Fetch(name)
+	if !ok {
+		return nil
+	}
+	if _, isFolder := entry.(base.Folder); isFolder {
+		return inmem.NewFolder(name)
+	}
+	return entry
+}
+
+// Translates a buffer of raw inotify events into notifications
+// Returns true once the subscribed folder itself is gone
+func (state *inotifySub) processEvents(buf []byte) (done bool) {
+	var offset int
+	for offset+syscall.SizeofInotifyEvent <= len(buf) {
+		event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+		nameStart := offset + syscall.SizeofInotifyEvent
+		nameEnd := nameStart + int(event.Len)
+		if nameEnd > len(buf) {
+			log.Println("WARN: Truncated inotify event")
+			return false
+		}
+		name := strings.TrimRight(string(buf[nameStart:nameEnd]), "\x00")
+		offset = nameEnd
+
+		if event.Mask&syscall.IN_Q_OVERFLOW != 0 {
+			log.Println("WARN: inotify queue overflowed, some events were lost")
+			continue
+		}
+
+		dir, ok := state.watches[event.Wd]
+		if !ok {
+			continue
+		}
+		if event.Mask&syscall.IN_IGNORED != 0 {
+			delete(state.watches, event.Wd)
+			continue
+		}
+
+		// the watched folder itself went away
+		if name == "" {
+			if event.Mask&(syscall.IN_DELETE_SELF|syscall.IN_MOVE_SELF) != 0 {
+				state.unload(dir.path)
+				if dir.path == "" {
+					state.sub.SendNotification("Removed", "", nil)
+					return true
+				}
+			}
+			continue
+		}
+
+		childPath := joinSubPath(dir.path, name)
+		switch {
+		case event.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0:
+			if entry := state.entryFor(dir.prefix, name); entry != nil {
+				state.sub.SendNotification("Added", childPath, entry)
+			}
+			if event.Mask&syscall.IN_ISDIR != 0 {
+				state.load(childPath, filepath.Join(dir.prefix, name), dir.height-1)
+			}
+
+		case event.Mask&(syscall.IN_DELETE|syscall.IN_MOVED_FROM) != 0:
+			if event.Mask&syscall.IN_ISDIR != 0 {
+				state.unload(childPath)
+			}
+			state.sub.SendNotification("Removed", childPath, nil)
+
+		case event.Mask&(syscall.IN_MODIFY|syscall.IN_CLOSE_WRITE|syscall.IN_ATTRIB) != 0:
+			if entry := state.entryFor(dir.prefix, name); entry != nil {
+				state.sub.SendNotification("Changed", childPath, entry)
+			}
+		}
+	}
+	return false
+}
+
+func joinSubPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "/" + name
+}
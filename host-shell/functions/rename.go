@@ -0,0 +1,37 @@
+package driver
+
+import (
+	"log"
+	"os"
+)
+
+// Atomically moves a path to a new name within the fs-root
+// Both paths must be on the same host filesystem
+func (s *Session) RenameImpl(input *RenameInput) string {
+	fromPath, ok := s.resolvePath("Rename():", input.From)
+	if !ok || fromPath == s.fsPrefix {
+		return "Failed: invalid source path"
+	}
+	toPath, ok := s.resolvePath("Rename():", input.To)
+	if !ok || toPath == s.fsPrefix {
+		return "Failed: invalid destination path"
+	}
+
+	if _, err := os.Lstat(fromPath); err != nil {
+		return "Failed: " + err.Error()
+	}
+	_, err := os.Lstat(toPath)
+	toExists := err == nil
+
+	// the source name goes away, so this is never just a creation
+	if !s.allowsWrite("Rename()", fromPath, true) || !s.allowsWrite("Rename()", toPath, toExists) {
+		return "Failed: mount doesn't allow changes"
+	}
+
+	if err := os.Rename(fromPath, toPath); err != nil {
+		log.Println("WARN Rename():", fromPath, "to", toPath, "failed:", err)
+		return "Failed: " + err.Error()
+	}
+	log.Println("Rename(): moved", fromPath, "to", toPath)
+	return "Ok"
+}
@@ -0,0 +1,35 @@
+package driver
+
+import (
+	"log"
+	"strconv"
+
+	"github.com/creack/pty"
+)
+
+// Changes the window size of the process's pseudo-terminal
+// The kernel lets the foreground process know with SIGWINCH
+func (p *Process) ResizeImpl(size *TerminalSize) string {
+	if p.tty == nil {
+		return "Failed: process has no tty"
+	}
+
+	rows, err := strconv.ParseUint(size.Rows, 10, 16)
+	if err != nil {
+		return "Failed: rows must be a number"
+	}
+	cols, err := strconv.ParseUint(size.Cols, 10, 16)
+	if err != nil {
+		return "Failed: cols must be a number"
+	}
+
+	err = pty.Setsize(p.tty, &pty.Winsize{
+		Rows: uint16(rows),
+		Cols: uint16(cols),
+	})
+	if err != nil {
+		log.Println("WARN: Failed resizing tty of pid", p.Pid, err)
+		return "Failed: " + err.Error()
+	}
+	return "Ok"
+}
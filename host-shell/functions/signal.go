@@ -0,0 +1,54 @@
+package driver
+
+import (
+	"log"
+	"strings"
+	"syscall"
+)
+
+var signalsByName = map[string]syscall.Signal{
+	"HUP":   syscall.SIGHUP,
+	"INT":   syscall.SIGINT,
+	"QUIT":  syscall.SIGQUIT,
+	"KILL":  syscall.SIGKILL,
+	"USR1":  syscall.SIGUSR1,
+	"USR2":  syscall.SIGUSR2,
+	"TERM":  syscall.SIGTERM,
+	"CONT":  syscall.SIGCONT,
+	"STOP":  syscall.SIGSTOP,
+	"WINCH": syscall.SIGWINCH,
+}
+
+// Sends a named signal, such as TERM or HUP, to the process
+// The SIG prefix is optional
+func (p *Process) SignalImpl(name string) string {
+	sig, ok := signalsByName[strings.TrimPrefix(strings.ToUpper(name), "SIG")]
+	if !ok {
+		return "Failed: unknown signal " + name
+	}
+	return p.sendSignal(sig)
+}
+
+// Signals the process's entire group, so children are included
+func (p *Process) sendSignal(sig syscall.Signal) string {
+	if status := p.Status.Get(); status != "Running" {
+		return "Failed: process is " + status
+	}
+
+	log.Println("Sending", signalName(sig), "to process", p.Pid)
+	if err := syscall.Kill(-p.cmd.Process.Pid, sig); err != nil {
+		log.Println("WARN: Failed to signal pid", p.Pid, err)
+		return "Failed: " + err.Error()
+	}
+	return "Ok"
+}
+
+// Names a signal the same way SignalImpl accepts it
+func signalName(sig syscall.Signal) string {
+	for name, known := range signalsByName {
+		if known == sig {
+			return name
+		}
+	}
+	return strings.ToUpper(sig.String())
+}
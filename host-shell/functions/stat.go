@@ -0,0 +1,80 @@
+package driver
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Reports metadata for a path within the fs-root, without reading it
+// Links are described themselves, instead of what they point to
+func (s *Session) StatImpl(path string) *FileStat {
+	subPath, ok := s.resolvePath("Stat():", path)
+	if !ok {
+		return nil
+	}
+
+	fileInfo, err := os.Lstat(subPath)
+	if err != nil {
+		log.Println("WARN Stat(): stat", subPath, "failed:", err)
+		return nil
+	}
+
+	relPath := strings.TrimPrefix(filepath.Clean("/"+path), "/")
+	return buildFileStat(relPath, fileInfo)
+}
+
+func buildFileStat(path string, fileInfo os.FileInfo) *FileStat {
+	stat := &FileStat{
+		Path:  path,
+		Size:  strconv.FormatInt(fileInfo.Size(), 10),
+		Mtime: fileInfo.ModTime().UTC().Format(time.RFC3339Nano),
+		Mode:  fmt.Sprintf("%04o", octalMode(fileInfo.Mode())),
+		Type:  fileTypeOf(fileInfo.Mode()),
+	}
+	if sys, ok := fileInfo.Sys().(*syscall.Stat_t); ok {
+		stat.Uid = strconv.FormatUint(uint64(sys.Uid), 10)
+		stat.Gid = strconv.FormatUint(uint64(sys.Gid), 10)
+	}
+	return stat
+}
+
+// Converts Go's permission bits back into the familiar octal form
+func octalMode(mode os.FileMode) uint32 {
+	bits := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		bits |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		bits |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		bits |= 01000
+	}
+	return bits
+}
+
+// Names an inode's type, using entry type names where they exist
+func fileTypeOf(mode os.FileMode) string {
+	switch {
+	case mode.IsRegular():
+		return "File"
+	case mode.IsDir():
+		return "Folder"
+	case mode&os.ModeSymlink != 0:
+		return "Link"
+	case mode&os.ModeNamedPipe != 0:
+		return "Pipe"
+	case mode&os.ModeSocket != 0:
+		return "Socket"
+	case mode&os.ModeDevice != 0:
+		return "Device"
+	default:
+		return "Unknown"
+	}
+}
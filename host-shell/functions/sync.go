@@ -0,0 +1,184 @@
+package driver
+
+import (
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/stardustapp/dustgo/lib/base"
+	"github.com/stardustapp/dustgo/lib/inmem"
+)
+
+// Mirrors any Folder onto a path within the fs-root
+// Only entries whose contents differ get written, so repeat syncs are cheap.
+// Strings land as small files. With prune, extra host entries are removed.
+func (s *Session) SyncImpl(input *SyncInput) *OperationReport {
+	if input.Source == nil {
+		return &OperationReport{Status: "Failed: no source given"}
+	}
+	destPath, ok := s.resolvePath("Sync():", input.Destination)
+	if !ok {
+		return &OperationReport{Status: "Failed: invalid destination path"}
+	}
+	if folder, ok := input.Source.(*hostFolder); ok && pathWithin(folder.prefix, destPath) {
+		return &OperationReport{Status: "Failed: can't sync a folder into itself"}
+	}
+
+	dest := s.getRoot().(*hostFolder)
+	if destPath != s.fsPrefix {
+		if dest, ok = ensureFolder(s.parentOf(destPath), filepath.Base(destPath)); !ok {
+			return &OperationReport{Status: "Failed: couldn't create destination"}
+		}
+	}
+
+	var changed []string
+	relRoot := strings.TrimPrefix(filepath.Clean("/"+input.Destination), "/")
+	ok = syncTree(dest, input.Source, relRoot, input.Prune == "yes", &changed)
+
+	status := "Ok"
+	if !ok {
+		status = "Failed: some entries weren't synced"
+	}
+	log.Println("Sync(): changed", len(changed), "entries at", destPath)
+	return &OperationReport{
+		Status: status,
+		Count:  strconv.Itoa(len(changed)),
+		Paths:  buildArrayFolder(changed...),
+	}
+}
+
+// Brings one host folder in line with the source folder,
+// recording the path of everything that was written or removed
+func syncTree(dest *hostFolder, source base.Folder, relPath string, prune bool, changed *[]string) bool {
+	syncedAll := true
+	seen := make(map[string]bool)
+	for _, name := range source.Children() {
+		seen[name] = true
+		entry, ok := source.Fetch(name)
+		if !ok {
+			log.Println("WARN Sync(): Failed to get child", name, "of", relPath)
+			syncedAll = false
+		} else if !syncEntry(dest, name, entry, path.Join(relPath, name), prune, changed) {
+			syncedAll = false
+		}
+	}
+
+	if !prune {
+		return syncedAll
+	}
+	if !dest.checkPrefix("Sync():") {
+		return false
+	}
+	for _, name := range dest.Children() {
+		if seen[name] {
+			continue
+		}
+		if !removeEntry(dest, name) {
+			syncedAll = false
+			continue
+		}
+		*changed = append(*changed, path.Join(relPath, name))
+	}
+	return syncedAll
+}
+
+func syncEntry(dest *hostFolder, name string, entry base.Entry, relPath string, prune bool, changed *[]string) bool {
+	existing, exists := dest.Fetch(name)
+
+	switch entry := entry.(type) {
+	case base.Folder:
+		folder, isFolder := existing.(*hostFolder)
+		if !isFolder {
+			if exists && !removeEntry(dest, name) {
+				return false
+			}
+			if folder, isFolder = ensureFolder(dest, name); !isFolder {
+				return false
+			}
+			*changed = append(*changed, relPath)
+		}
+		return syncTree(folder, entry, relPath, prune, changed)
+
+	case base.String:
+		// there's no host equivalent, so keep the value as the contents
+		return syncFile(dest, name, inmem.NewFile(name, []byte(entry.Get())), existing, relPath, changed)
+
+	case base.File:
+		return syncFile(dest, name, entry, existing, relPath, changed)
+
+	case base.Link:
+		if link, ok := existing.(base.Link); ok && link.Target() == entry.Target() {
+			return true
+		}
+		if exists && !removeEntry(dest, name) {
+			return false
+		}
+		if !dest.Put(name, entry) {
+			return false
+		}
+		*changed = append(*changed, relPath)
+		return true
+
+	default:
+		log.Println("WARN Sync(): unsupported entry at", relPath)
+		return false
+	}
+}
+
+// Writes a file only when it's missing or its contents have changed
+func syncFile(dest *hostFolder, name string, file base.File, existing base.Entry, relPath string, changed *[]string) bool {
+	if existing != nil {
+		current, isFile := existing.(*hostFile)
+		if isFile && !filesDiffer(current, file) {
+			return true
+		}
+
+		// files get replaced atomically by Put, anything else has to go first
+		if !isFile && !removeEntry(dest, name) {
+			return false
+		}
+	}
+
+	if !dest.Put(name, file) {
+		return false
+	}
+	*changed = append(*changed, relPath)
+	return true
+}
+
+// Compares a host file with any other, only hashing when sizes match
+func filesDiffer(current *hostFile, file base.File) bool {
+	if current.GetSize() != file.GetSize() {
+		return true
+	}
+
+	currentHash, err := hashHostFile(current.path)
+	if err != nil {
+		log.Println("WARN Sync(): hashing", current.path, "failed:", err)
+		return true
+	}
+	hash, err := hashReader(&entryReader{file: file})
+	if err != nil {
+		log.Println("WARN Sync(): hashing source for", current.path, "failed:", err)
+		return true
+	}
+	return hash != currentHash
+}
+
+// Removes a host entry of any type, with everything inside it
+func removeEntry(dest *hostFolder, name string) bool {
+	subPath, ok := dest.childPath("Sync():", name)
+	if !ok || !dest.session.allowsWrite("Sync(): remove", subPath, true) {
+		return false
+	}
+
+	log.Println("WARN Sync(): DELETING", subPath)
+	if err := os.RemoveAll(subPath); err != nil {
+		log.Println("WARN Sync(): FAILED to delete", subPath, err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,52 @@
+package driver
+
+import (
+	"log"
+	"os"
+	"syscall"
+	"time"
+)
+
+// Updates a path's modification time, creating an empty file if needed
+// The time defaults to now, and is otherwise given in RFC3339
+func (s *Session) TouchImpl(input *TouchInput) string {
+	mtime := time.Now()
+	if input.Mtime != "" {
+		var err error
+		if mtime, err = time.Parse(time.RFC3339Nano, input.Mtime); err != nil {
+			return "Failed: mtime must be RFC3339"
+		}
+	}
+
+	subPath, ok := s.resolvePath("Touch():", input.Path)
+	if !ok {
+		return "Failed: invalid path"
+	}
+
+	fileInfo, err := os.Lstat(subPath)
+	exists := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return "Failed: " + err.Error()
+	}
+	if !s.allowsWrite("Touch()", subPath, exists) {
+		return "Failed: mount doesn't allow changes"
+	}
+
+	if !exists {
+		file, err := os.OpenFile(subPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL|syscall.O_NOFOLLOW, 0644)
+		if err != nil {
+			log.Println("WARN Touch(): create", subPath, "failed:", err)
+			return "Failed: " + err.Error()
+		}
+		file.Close()
+	} else if fileInfo.Mode()&os.ModeSymlink != 0 {
+		// chtimes always follows links
+		return "Failed: can't touch a link"
+	}
+
+	if err := os.Chtimes(subPath, mtime, mtime); err != nil {
+		log.Println("WARN Touch():", subPath, "failed:", err)
+		return "Failed: " + err.Error()
+	}
+	return "Ok"
+}
@@ -1,9 +1,64 @@
 package driver
 
 import (
+	"log"
+	"os"
+	"syscall"
+
 	"github.com/stardustapp/dustgo/lib/base"
 )
 
-func (p *Process) WriteStdinImpl(chunk base.File) {
+// Feeds a chunk into the process's stdin
+// An empty chunk closes stdin, signalling EOF to the process
+// Processes on a pty always accept input, as the terminal is interactive
+func (p *Process) WriteStdinImpl(chunk base.File) string {
+	if p.Opts.EnableStdin != "yes" && p.Opts.EnableTty != "yes" {
+		return "Failed: stdin isn't enabled"
+	}
+
+	// one writer at a time, so chunks never interleave
+	p.stdinMutex.Lock()
+	defer p.stdinMutex.Unlock()
+
+	if status := p.Status.Get(); status != "Running" {
+		return "Failed: process is " + status
+	}
+	if p.stdin == nil {
+		return "Failed: stdin is already closed"
+	}
+
+	var data []byte
+	if chunk != nil {
+		data = chunk.Read(0, int(chunk.GetSize()))
+	}
+
+	if len(data) == 0 {
+		if p.tty != nil {
+			// closing a pty master would hang up the whole terminal,
+			// so send the terminal's EOF character instead
+			data = []byte{4}
+		} else {
+			err := p.stdin.Close()
+			p.stdin = nil
+			if err != nil {
+				log.Println("WARN: Failed closing stdin of pid", p.Pid, err)
+				return "Failed: " + err.Error()
+			}
+			return "Ok: stdin closed"
+		}
+	}
+
+	if _, err := p.stdin.Write(data); err != nil {
+		log.Println("WARN: Failed writing stdin of pid", p.Pid, err)
 
+		// the process may have gone away while we were writing
+		if pathErr, ok := err.(*os.PathError); ok && pathErr.Err == syscall.EPIPE {
+			return "Failed: process has exited"
+		}
+		if status := p.Status.Get(); status != "Running" {
+			return "Failed: process is " + status
+		}
+		return "Failed: " + err.Error()
+	}
+	return "Ok"
 }
@@ -0,0 +1,219 @@
+package driver
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// Orphans touched more recently than this might still be in use, so they're kept
+const gcMinIdle = 10 * time.Minute
+
+// How many keys to SCAN or pipeline per round trip
+const gcBatchSize = 1000
+
+// Mark-and-sweep collection of nodes which can't be reached from the root
+// Everything linked from <prefix>root is marked, then nodes/* is swept.
+// Orphans are re-verified right before deletion. Any with keys used within
+// gcMinIdle are left for a later run, as an entry someone still holds
+// could be written to, or Put back into a folder by its existing nid.
+func (c *Client) CollectGarbageImpl(input *GcInput) *GcReport {
+	c.gcMutex.Lock()
+	defer c.gcMutex.Unlock()
+
+	dryRun := input != nil && input.DryRun == "yes"
+	marked, err := c.markNodes()
+	if err != nil {
+		log.Println("WARN: redisns gc couldn't mark nodes:", err)
+		return &GcReport{Status: "Failed: " + err.Error()}
+	}
+	nodes, err := c.scanNodes()
+	if err != nil {
+		log.Println("WARN: redisns gc couldn't scan nodes:", err)
+		return &GcReport{Status: "Failed: " + err.Error()}
+	}
+
+	orphans := make(map[string][]string)
+	var orphanKeys []string
+	for nid, keys := range nodes {
+		if !marked[nid] {
+			orphans[nid] = keys
+			orphanKeys = append(orphanKeys, keys...)
+		}
+	}
+	report := &GcReport{
+		Status:         "Ok: dry run",
+		ReachableNodes: strconv.Itoa(len(marked)),
+		OrphanNodes:    strconv.Itoa(len(orphans)),
+		OrphanKeys:     strconv.Itoa(len(orphanKeys)),
+		OrphanBytes:    strconv.FormatInt(c.memoryUsage(orphanKeys), 10),
+		RemovedNodes:   "0",
+		SkippedNodes:   "0",
+	}
+	log.Println("redisns gc found", len(orphans), "orphaned nodes, with", len(marked), "reachable")
+	if dryRun || len(orphans) == 0 {
+		if !dryRun {
+			report.Status = "Ok"
+		}
+		return report
+	}
+
+	// things could have been linked while we were scanning
+	if marked, err = c.markNodes(); err != nil {
+		log.Println("WARN: redisns gc couldn't re-mark nodes:", err)
+		report.Status = "Failed: " + err.Error()
+		return report
+	}
+
+	var removed, skipped int
+	for nid, keys := range orphans {
+		if marked[nid] || c.recentlyUsed(keys) {
+			skipped++
+			continue
+		}
+		if err := c.svc.Del(keys...).Err(); err != nil {
+			log.Println("WARN: redisns gc couldn't delete node", nid, err)
+			skipped++
+			continue
+		}
+		removed++
+	}
+
+	log.Println("redisns gc removed", removed, "orphaned nodes, skipped", skipped)
+	report.Status = "Ok"
+	report.RemovedNodes = strconv.Itoa(removed)
+	report.SkippedNodes = strconv.Itoa(skipped)
+	return report
+}
+
+// Runs the collector forever, for mounts with a gc-interval
+func (c *Client) collectOnSchedule(interval time.Duration) {
+	for range time.Tick(interval) {
+		c.CollectGarbageImpl(&GcInput{})
+	}
+}
+
+// Walks every folder's children from the root, breadth-first,
+// returning the set of nids which are still linked somewhere
+func (c *Client) markNodes() (map[string]bool, error) {
+	rootNid := c.svc.Get(c.prefix + "root").Val()
+	if rootNid == "" {
+		// sweeping without a root would delete everything
+		return nil, errors.New("namespace has no root node")
+	}
+
+	marked := map[string]bool{rootNid: true}
+	pending := []string{rootNid}
+	for len(pending) > 0 {
+		batch := pending
+		if len(batch) > gcBatchSize {
+			batch = batch[:gcBatchSize]
+		}
+		pending = pending[len(batch):]
+
+		cmds := make([]*redis.StringSliceCmd, len(batch))
+		_, err := c.svc.Pipelined(func(pipe redis.Pipeliner) error {
+			for idx, nid := range batch {
+				cmds[idx] = pipe.HVals(c.prefixFor(nid, "children"))
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, cmd := range cmds {
+			for _, nid := range cmd.Val() {
+				if !marked[nid] {
+					marked[nid] = true
+					pending = append(pending, nid)
+				}
+			}
+		}
+	}
+	return marked, nil
+}
+
+// Lists every node key in the namespace, grouped by nid
+func (c *Client) scanNodes() (map[string][]string, error) {
+	nodesPrefix := c.prefix + "nodes/"
+	nodes := make(map[string][]string)
+	seen := make(map[string]bool) // SCAN can repeat keys
+
+	var cursor uint64
+	for {
+		keys, next, err := c.svc.Scan(cursor, nodesPrefix+"*", gcBatchSize).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			nid := strings.SplitN(strings.TrimPrefix(key, nodesPrefix), ":", 2)[0]
+			nodes[nid] = append(nodes[nid], key)
+		}
+
+		if next == 0 {
+			return nodes, nil
+		}
+		cursor = next
+	}
+}
+
+// Totals what Redis says the keys take up, skipping any it won't report
+func (c *Client) memoryUsage(keys []string) (total int64) {
+	for len(keys) > 0 {
+		batch := keys
+		if len(batch) > gcBatchSize {
+			batch = batch[:gcBatchSize]
+		}
+		keys = keys[len(batch):]
+
+		cmds := make([]*redis.Cmd, len(batch))
+		c.svc.Pipelined(func(pipe redis.Pipeliner) error {
+			for idx, key := range batch {
+				cmds[idx] = pipe.Do("MEMORY", "USAGE", key)
+			}
+			return nil
+		})
+		for _, cmd := range cmds {
+			if bytes, err := cmd.Int64(); err == nil {
+				total += bytes
+			}
+		}
+	}
+	return
+}
+
+// Checks whether any of a node's keys have been touched lately
+// Idle times aren't tracked under LFU eviction, so then nothing is collected
+func (c *Client) recentlyUsed(keys []string) bool {
+	cmds := make([]*redis.DurationCmd, len(keys))
+	c.svc.Pipelined(func(pipe redis.Pipeliner) error {
+		for idx, key := range keys {
+			cmds[idx] = pipe.ObjectIdleTime(key)
+		}
+		return nil
+	})
+
+	for _, cmd := range cmds {
+		idle, err := cmd.Result()
+		if err == redis.Nil {
+			continue // already gone
+		}
+		if err != nil {
+			log.Println("WARN: redisns gc couldn't check idle time:", err)
+			return true
+		}
+		if idle < gcMinIdle {
+			return true
+		}
+	}
+	return false
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"time"
 
 	"github.com/stardustapp/dustgo/lib/base"
 	"github.com/stardustapp/dustgo/lib/extras"
@@ -46,6 +47,14 @@ func (r *Root) OpenImpl(opts *MountOpts) *Client {
 	client.Root = client.getRoot()
 	log.Printf("built client %+v", client)
 
+	if opts.GcInterval != "" {
+		if interval, err := time.ParseDuration(opts.GcInterval); err != nil || interval <= 0 {
+			log.Println("WARN: Ignoring invalid gc-interval", opts.GcInterval, err)
+		} else {
+			go client.collectOnSchedule(interval)
+		}
+	}
+
 	if r.Sessions == nil {
 		// TODO: this should be made already
 		r.Sessions = inmem.NewObscuredFolder("sessions")
@@ -61,32 +70,142 @@ func (c *Client) getRoot() base.Folder {
 	rootNid := c.svc.Get(c.prefix + "root").Val()
 	if rootNid == "" {
 		log.Println("Initializing redisns root")
-		rootNid = c.newNode("root", "Folder")
-		c.svc.Set(c.prefix+"root", rootNid, 0)
+		root := &nodeSpec{typeStr: "Folder", name: "root"}
+		if !c.createNodes(root, c.prefix+"root", "") {
+			log.Fatalln("FATAL: Couldn't make redisns root")
+		}
+		rootNid = root.nid
 	}
 	return c.getEntry(rootNid, false).(base.Folder)
 }
 
-func (c *Client) newNode(name, typeStr string) string {
-	var nid string
-	var attempt int
-	for attempt < 5 {
-		attempt++
+// Returned from a createNodes transaction when a fresh nid is already in use
+var errNidTaken = errors.New("nid already taken")
 
-		nid = extras.GenerateId() + "b"
-		if c.svc.SetNX(c.prefixFor(nid, "type"), typeStr, 0).Val() == false {
-			log.Println("WARN: Redis node", nid, "already exists, couldn't make new", name, typeStr, "- attempt", attempt)
-		} else {
-			break
+// A node to be written by createNodes, or one that already exists
+type nodeSpec struct {
+	nid      string
+	existing bool
+	typeStr  string
+	name     string
+	field    string
+	value    interface{}
+	children []childLink
+}
+
+type childLink struct {
+	name string
+	node *nodeSpec
+}
+
+// Gathers up an entry and everything within it, without writing anything
+// Folders already in redis are referenced instead of copied
+func (c *Client) specFor(entry base.Entry) *nodeSpec {
+	switch entry := entry.(type) {
+
+	case *redisNsFolder:
+		return &nodeSpec{nid: entry.nid, existing: true}
+
+	case base.Folder:
+		node := &nodeSpec{typeStr: "Folder", name: entry.Name()}
+		for _, child := range entry.Children() {
+			childEnt, ok := entry.Fetch(child)
+			if !ok {
+				log.Println("redisns: Failed to get child", child, "of", entry.Name())
+			} else if childNode := c.specFor(childEnt); childNode != nil {
+				node.children = append(node.children, childLink{child, childNode})
+			}
 		}
+		return node
+
+	case base.String:
+		return &nodeSpec{typeStr: "String", name: entry.Name(), field: "value", value: entry.Get()}
+
+	case base.Link:
+		return &nodeSpec{typeStr: "Link", name: entry.Name(), field: "target", value: entry.Target()}
+
+	case base.File:
+		size := entry.GetSize()
+		data := entry.Read(0, int(size))
+		return &nodeSpec{typeStr: "File", name: entry.Name(), field: "raw-data", value: data}
 	}
-	if attempt == 5 {
-		log.Fatalln("FATAL: No room in redis to make new", name, typeStr)
+
+	log.Println("redisns: Can't store entry", entry.Name())
+	return nil
+}
+
+// Writes out every new node in the tree, then links the top one in,
+// all in one MULTI/EXEC. The new nodes' type keys are WATCHed and checked
+// first, and fresh nids are picked for each attempt in case any are taken.
+func (c *Client) createNodes(top *nodeSpec, linkKey, linkName string) bool {
+	for attempt := 1; attempt <= 5; attempt++ {
+		var nodes []*nodeSpec
+		var assign func(node *nodeSpec)
+		assign = func(node *nodeSpec) {
+			if node.existing {
+				return
+			}
+			node.nid = extras.GenerateId() + "b"
+			if node.value == nil {
+				node.value = ""
+			}
+			nodes = append(nodes, node)
+			for _, child := range node.children {
+				assign(child.node)
+			}
+		}
+		assign(top)
+
+		typeKeys := make([]string, len(nodes))
+		for idx, node := range nodes {
+			typeKeys[idx] = c.prefixFor(node.nid, "type")
+		}
+
+		err := c.svc.Watch(func(tx *redis.Tx) error {
+			taken, err := tx.Exists(typeKeys...).Result()
+			if err != nil {
+				return err
+			}
+			if taken > 0 {
+				return errNidTaken
+			}
+
+			_, err = tx.Pipelined(func(pipe redis.Pipeliner) error {
+				for _, node := range nodes {
+					pipe.Set(c.prefixFor(node.nid, "type"), node.typeStr, 0)
+					pipe.Set(c.prefixFor(node.nid, "name"), node.name, 0)
+					if node.field != "" {
+						pipe.Set(c.prefixFor(node.nid, node.field), node.value, 0)
+					}
+					for _, child := range node.children {
+						pipe.HSet(c.prefixFor(node.nid, "children"), child.name, child.node.nid)
+					}
+				}
+
+				if linkName == "" {
+					pipe.Set(linkKey, top.nid, 0)
+				} else {
+					pipe.HSet(linkKey, linkName, top.nid)
+				}
+				return nil
+			})
+			return err
+		}, typeKeys...)
+
+		switch err {
+		case nil:
+			log.Println("Created", len(nodes), "redisns nodes for", top.name, "type", top.typeStr)
+			return true
+		case errNidTaken, redis.TxFailedErr:
+			log.Println("WARN: Redis nodes changed or already exist, couldn't make new", top.name, top.typeStr, "- attempt", attempt)
+		default:
+			log.Println("WARN: Couldn't create redisns nodes for", top.name, "-", err)
+			return false
+		}
 	}
 
-	c.svc.Set(c.prefixFor(nid, "name"), name, 0)
-	log.Println("Created redisns node", nid, "named", name, "type", typeStr)
-	return nid
+	log.Println("WARN: No room in redis to make new", top.name, top.typeStr)
+	return false
 }
 
 func (c *Client) prefixFor(nid, key string) string {
@@ -123,9 +242,16 @@ func (c *Client) getEntry(nid string, shallow bool) base.Entry {
 		return inmem.NewLink(name, value)
 
 	case "File":
-		// TODO: writable file struct!
-		data, _ := c.svc.Get(prefix + "raw-data").Bytes()
-		return inmem.NewFile(name, data)
+		if shallow {
+			data, _ := c.svc.Get(prefix + "raw-data").Bytes()
+			return inmem.NewFile(name, data)
+		} else {
+			return &redisNsFile{
+				client: c,
+				nid:    nid,
+				key:    prefix + "raw-data",
+			}
+		}
 
 	case "Folder":
 		if shallow {
@@ -179,58 +305,126 @@ func (e *redisNsFolder) Fetch(name string) (entry base.Entry, ok bool) {
 }
 
 // replaces whatever node reference was already there w/ a new node
+// the new node and anything within it are all written in one go
 func (e *redisNsFolder) Put(name string, entry base.Entry) (ok bool) {
 	if entry == nil {
-		// unlink a child, leaves it around tho
-		// TODO: garbage collection!
+		// unlink a child, leaves it around for CollectGarbage to sweep
 		e.client.svc.HDel(e.prefix+"children", name)
 		return true
 	}
 
-	var nid string
-	switch entry := entry.(type) {
+	// strings over strings keep their node, so nids don't churn
+	if str, ok := entry.(base.String); ok {
+		updated, err := updateStringScript.Run(e.client.svc,
+			[]string{e.prefix + "children"}, e.client.prefix, name, str.Get()).Int64()
+		if err != nil {
+			log.Println("WARN: redisns string update failed for", name, "on node", e.nid, "-", err)
+		} else if updated == 1 {
+			return true
+		}
+	}
 
-	case *redisNsFolder:
+	node := e.client.specFor(entry)
+	if node == nil {
+		log.Println("redisns put failed for", name, "on node", e.nid)
+		return false
+	}
+
+	if node.existing {
 		// the folder already exists in redis, make a reference
-		nid = entry.nid
+		return e.client.svc.HSet(e.prefix+"children", name, node.nid).Err() == nil
+	}
+	if !e.client.createNodes(node, e.prefix+"children", name) {
+		log.Println("redisns put failed for", name, "on node", e.nid)
+		return false
+	}
+	return true
+}
 
-	case base.Folder:
-		nid = e.client.newNode(entry.Name(), "Folder")
-		dest := e.client.getEntry(nid, false).(base.Folder)
+// Sets the value of a folder's String child, if it has one by that name
+// KEYS[1] is the children hash, ARGV is the key prefix, child name and value.
+// Returns 0 without writing when the child is missing or isn't a String.
+var updateStringScript = redis.NewScript(`
+local nid = redis.call("HGET", KEYS[1], ARGV[2])
+if not nid then
+  return 0
+end
+local key = ARGV[1] .. "nodes/" .. nid .. ":"
+if redis.call("GET", key .. "type") ~= "String" then
+  return 0
+end
+redis.call("SET", key .. "value", ARGV[3])
+return 1
+`)
+
+// Persists as a File from an redisNs instance
+// Reads and writes only touch the requested range of the raw-data key
+type redisNsFile struct {
+	client *Client
+	nid    string
+	key    string
+}
 
-		// recursively copy entire folder to redis
-		for _, child := range entry.Children() {
-			if childEnt, ok := entry.Fetch(child); ok {
-				dest.Put(child, childEnt)
-			} else {
-				log.Println("redisns: Failed to get child", child, "of", name)
-			}
-		}
+var _ base.File = (*redisNsFile)(nil)
 
-	case base.String:
-		nid = e.client.newNode(entry.Name(), "String")
-		e.client.svc.Set(e.client.prefixFor(nid, "value"), entry.Get(), 0)
+func (e *redisNsFile) Name() string {
+	return e.client.nameOf(e.nid)
+}
 
-	case base.Link:
-		nid = e.client.newNode(entry.Name(), "Link")
-		e.client.svc.Set(e.client.prefixFor(nid, "target"), entry.Target(), 0)
+func (e *redisNsFile) GetSize() int64 {
+	return e.client.svc.StrLen(e.key).Val()
+}
 
-	case base.File:
-		nid = e.client.newNode(entry.Name(), "File")
+func (e *redisNsFile) Read(offset int64, numBytes int) (data []byte) {
+	if offset < 0 || numBytes <= 0 {
+		return nil
+	}
+	data, err := e.client.svc.GetRange(e.key, offset, offset+int64(numBytes)-1).Bytes()
+	if err != nil {
+		log.Println("WARN: redisns file", e.nid, "read failed:", err)
+		return nil
+	}
+	return data
+}
 
-		size := entry.GetSize()
-		data := entry.Read(0, int(size))
-		e.client.svc.Set(e.client.prefixFor(nid, "raw-data"), data, 0)
+// Overwrites exactly the requested range in place,
+// with redis zero-filling any gap past the end
+func (e *redisNsFile) Write(offset int64, data []byte) (numBytes int) {
+	if offset < 0 {
+		return 0
+	}
 
+	err := e.client.svc.SetRange(e.key, offset, string(data)).Err()
+	if err != nil {
+		log.Println("WARN: redisns file", e.nid, "write failed:", err)
+		return 0
 	}
+	return len(data)
+}
 
-	if nid == "" {
-		log.Println("redisns put failed for", name, "on node", e.nid)
+// Shrinks or zero-extends a string to exactly the requested length
+var truncateScript = redis.NewScript(`
+local size = redis.call("STRLEN", KEYS[1])
+local length = tonumber(ARGV[1])
+if length == 0 then
+  redis.call("SET", KEYS[1], "")
+elseif length < size then
+  redis.call("SET", KEYS[1], redis.call("GETRANGE", KEYS[1], 0, length - 1))
+elseif length > size then
+  redis.call("SETRANGE", KEYS[1], length - 1, "\0")
+end
+return length
+`)
+
+func (e *redisNsFile) Truncate(byteCount int64) (ok bool) {
+	if byteCount < 0 {
+		return false
+	}
+	if err := truncateScript.Run(e.client.svc, []string{e.key}, byteCount).Err(); err != nil {
+		log.Println("WARN: redisns file", e.nid, "truncate failed:", err)
 		return false
-	} else {
-		e.client.svc.HSet(e.prefix+"children", name, nid)
-		return true
 	}
+	return true
 }
 
 type redisNsString struct {
@@ -243,6 +437,19 @@ type redisNsString struct {
 
 var _ base.String = (*redisNsString)(nil)
 
+func (e *redisNsString) Get() (value string) {
+	return e.client.svc.Get(e.client.prefixFor(e.nid, "value")).Val()
+}
+
+// Updates the existing node, instead of linking in a new one
+func (e *redisNsString) Set(value string) {
+	if err := e.client.svc.Set(e.client.prefixFor(e.nid, "value"), value, 0).Err(); err != nil {
+		log.Println("WARN: redisns string", e.nid, "set failed:", err)
+		return
+	}
+	e.String.Set(value)
+}
+
 ///////////////////////////////////////////
 // Experimental subscribe() impl
 // Here be dragons!
@@ -256,6 +463,7 @@ type subState struct {
 
 type subNode struct {
 	nid      string
+	parent   *subNode
 	children map[string]*subNode
 	path     string
 	height   int // remaining children depths
@@ -281,6 +489,7 @@ func (n *subNode) load(state *subState, asChanged bool) {
 		for name, nid := range state.client.svc.HGetAll(childKey).Val() {
 			node := &subNode{
 				nid:      nid,
+				parent:   n,
 				children: make(map[string]*subNode),
 				path:     prefix + name,
 				height:   n.height - 1,
@@ -348,6 +557,7 @@ func (n *subNode) processEvent(action, field string, state *subState) {
 			// add the new child
 			node := &subNode{
 				nid:      nid,
+				parent:   n,
 				children: make(map[string]*subNode),
 				path:     prefix + name,
 				height:   n.height - 1,
@@ -370,11 +580,63 @@ func (n *subNode) processEvent(action, field string, state *subState) {
 			log.Println("update: child", name, "nid", node.nid, "was removed")
 		}
 
+	} else if action == "del" || action == "expired" {
+		// the node itself is going away, so forget about it
+		log.Println("redis node", n.nid, "path", n.path, "lost its", field, "- removing")
+		if n.parent != nil {
+			for name, node := range n.parent.children {
+				if node == n {
+					delete(n.parent.children, name)
+				}
+			}
+		}
+		n.unload(state, true)
+
+	} else if field == "raw-data" {
+		// files can be big and written in small pieces, so only the size is sent
+		state.sub.SendNotification("Changed", n.path, &resizedFile{
+			name: state.client.nameOf(n.nid),
+			size: state.client.svc.StrLen(state.client.prefixFor(n.nid, "raw-data")).Val(),
+		})
+
+	} else if field == "value" || field == "target" || field == "name" || field == "type" {
+		// data changed in place, without a re-link
+		state.sub.SendNotification("Changed", n.path, state.client.getEntry(n.nid, true))
+
 	} else {
 		log.Println("WARN: redis node", n.nid, "path", n.path, "got unimpl event", action, field)
 	}
 }
 
+// Stands in for a File whose contents changed, without loading them
+// Subscribers learn the new size, and can read just the range they need.
+type resizedFile struct {
+	name string
+	size int64
+}
+
+var _ base.File = (*resizedFile)(nil)
+
+func (e *resizedFile) Name() string {
+	return e.name
+}
+
+func (e *resizedFile) GetSize() int64 {
+	return e.size
+}
+
+func (e *resizedFile) Read(offset int64, numBytes int) []byte {
+	return []byte{}
+}
+
+func (e *resizedFile) Write(offset int64, data []byte) int {
+	return 0
+}
+
+func (e *resizedFile) Truncate(byteCount int64) bool {
+	return false
+}
+
 func (e *redisNsFolder) Subscribe(s *skylink.Subscription) (err error) {
 	if resp := e.client.svc.ConfigSet("notify-keyspace-events", "AK"); resp.Err() != nil {
 		log.Println("Couldn't configure keyspace events.", resp.Err())
@@ -476,12 +738,18 @@ func (e *redisNsString) Subscribe(s *skylink.Subscription) (err error) {
 		pubsub.Close()
 	}(s.StopC)
 
+	// values are set in place too, so the current node's value key is watched
+	valueEvtKey := func(nid string) string {
+		return "__keyspace@0__:" + e.client.prefixFor(nid, "value")
+	}
+
 	go func() {
 		defer log.Println("stopped string sub loop")
 		defer s.Close()
 
 		latestNid := e.client.svc.HGet(childKey, e.field).Val()
 		if latestNid != "" {
+			pubsub.Subscribe(valueEvtKey(latestNid))
 			s.SendNotification("Added", "", e.client.getEntry(latestNid, true))
 		}
 		s.SendNotification("Ready", "", nil)
@@ -489,10 +757,27 @@ func (e *redisNsString) Subscribe(s *skylink.Subscription) (err error) {
 		log.Println("starting string sub loop")
 		for msg := range pubsub.Channel() {
 			log.Println("string sub received payload", msg.Payload, "for", e.parent.nid)
+			if msg.Channel != evtKey {
+				// an in-place update, unless it's a straggler from an old node
+				if latestNid != "" && msg.Channel == valueEvtKey(latestNid) &&
+					msg.Payload != "del" && msg.Payload != "expired" {
+					s.SendNotification("Changed", "", e.client.getEntry(latestNid, true))
+				}
+				continue
+			}
+
 			newNid := e.client.svc.HGet(childKey, e.field).Val()
 			if newNid == latestNid {
 				continue
-			} else if newNid == "" {
+			}
+			if latestNid != "" {
+				pubsub.Unsubscribe(valueEvtKey(latestNid))
+			}
+			if newNid != "" {
+				pubsub.Subscribe(valueEvtKey(newNid))
+			}
+
+			if newNid == "" {
 				s.SendNotification("Removed", "", nil)
 			} else if latestNid == "" {
 				s.SendNotification("Added", "", e.client.getEntry(newNid, true))